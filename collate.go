@@ -1,4 +1,5 @@
 // https://www.census.gov/prod/cen2010/doc/pl94-171.pdf
+// https://www2.census.gov/programs-surveys/decennial/2020/technical-documentation/complete-tech-docs/summary-file/2020Census_PL94_171Redistricting_StatesTechDoc_English.pdf
 
 package main
 
@@ -6,7 +7,6 @@ import (
 	"bufio"
 	"compress/gzip"
 	"encoding/csv"
-	"encoding/gob"
 	"flag"
 	"fmt"
 	"io"
@@ -14,318 +14,763 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/kshedden/segregation/seglib"
 	"github.com/paulmach/orb"
 )
 
-const (
-	baseDir = "/dsi/stage/stage/cscar-census"
-)
-
 var (
+	cfg seglib.Config
+
 	dir string
 
 	sumlevel seglib.RegionType
 
 	year int
 
-	// 0, 1, 2 correspond to the target sumlevel code for cousub, tract, and blockgroup,
-	// respectively
-	sumlevelCodes []string
+	// The summary level code of the target regions in the geographic header
+	sumlevelCode string
 
-	out *gob.Encoder
+	// User-defined groups, counted for every region
+	groups []seglib.Group
+
+	// If true, records that can't be parsed are skipped rather than
+	// stopping the run
+	lenient bool
 )
 
 func main() {
 
 	flag.IntVar(&year, "year", 0, "Census year")
 	var sl string
-	flag.StringVar(&sl, "sumlevel", "", "Summary level ('blockgroup', 'tract', or 'cousub')")
+	flag.StringVar(&sl, "sumlevel", "", "Summary level, one of "+strings.Join(seglib.RegionTypeNames(), ", "))
+	pr := flag.Bool("pr", false, "Include Puerto Rico")
+	statesf := flag.String("states", "", "Comma-separated postal or FIPS codes of the states to collate (default all)")
+	groupsf := flag.String("groups", "", "File of group definitions over the PL columns")
+	strict := flag.Bool("strict", false, "Stop at the first record that can't be parsed (the default)")
+	flag.BoolVar(&lenient, "lenient", false, "Skip and log records that can't be parsed")
+	workers := flag.Int("workers", 4, "Number of states to collate concurrently")
+	cfg.RegisterFlags(flag.CommandLine)
 	flag.Parse()
+	if err := cfg.Resolve(); err != nil {
+		panic(err)
+	}
 
-	switch sl {
-	case "cousub":
-		sumlevel = seglib.CountySubdivision
-	case "tract":
-		sumlevel = seglib.Tract
-	case "blockgroup":
-		sumlevel = seglib.BlockGroup
-	default:
-		msg := fmt.Sprintf("Unkown sumlevel '%s'\n", sl)
-		panic(msg)
+	if *strict && lenient {
+		panic("-strict and -lenient can't both be set\n")
+	}
+
+	if *workers < 1 {
+		panic("-workers must be positive\n")
+	}
+
+	states, err := seglib.ParseStates(*statesf, *pr)
+	if err != nil {
+		panic(err)
+	}
+
+	sumlevel, err = seglib.ParseRegionType(sl)
+	if err != nil {
+		panic(err)
 	}
 
 	switch year {
-	case 2010:
-		sumlevelCodes = []string{"060", "140", "150"}
-	case 2000:
-		sumlevelCodes = []string{"060", "140", "740"}
+	case 1990, 2000, 2010, 2020:
 	default:
 		panic("invalid year")
 	}
 
-	dir = path.Join(baseDir, "redistricting-data", fmt.Sprintf("%4d", year))
+	sumlevelCode, err = sumlevel.SumlevelCode(year)
+	if err != nil {
+		panic(err)
+	}
 
-	var fname string
-	switch sumlevel {
-	case seglib.CountySubdivision:
-		fname = fmt.Sprintf("segregation_raw_cousub_%4d.gob.gz", year)
-	case seglib.Tract:
-		fname = fmt.Sprintf("segregation_raw_tract_%4d.gob.gz", year)
-	case seglib.BlockGroup:
-		fname = fmt.Sprintf("segregation_raw_blockgroup_%4d.gob.gz", year)
-	default:
-		panic("Unrecognized summary level\n")
+	if *groupsf != "" {
+		if year == 1990 {
+			panic("Groups are not supported for 1990\n")
+		}
+		groups, err = seglib.ReadGroups(*groupsf)
+		if err != nil {
+			panic(err)
+		}
 	}
 
-	fid, err := os.Create(fname)
+	layout, err := seglib.GeoLayout(year)
 	if err != nil {
 		panic(err)
 	}
-	defer fid.Close()
+	if err := layout.Check(); err != nil {
+		panic(err)
+	}
 
-	gid := gzip.NewWriter(fid)
-	defer gid.Close()
+	dir = cfg.RedistrictingDir(year)
 
-	out = gob.NewEncoder(gid)
+	fname := cfg.OutPath(fmt.Sprintf("segregation_raw_%s_%4d.gob.gz", sumlevel, year))
+	hdr := seglib.Header{
+		Program: "collate",
+		Year:    year,
+		Type:    sumlevel,
+		Inputs:  hashInputs(states, *workers),
+	}
+	out, err := seglib.CreateRegions(fname, hdr)
+	if err != nil {
+		panic(err)
+	}
 
+	// The states are written in order as they are completed, so that the
+	// output does not depend on the number of workers.
 	var m int
-	for _, state := range seglib.States {
-		n := doState(state[1])
-		fmt.Printf("Found %d records in state %s\n", n, state[1])
+	skipped := make(map[string]int)
+	collateStates(states, *workers, func(r *stateResult) {
+		for _, msg := range r.warnings {
+			os.Stderr.WriteString(msg)
+		}
+		for i := range r.regions {
+			if err := out.Write(&r.regions[i]); err != nil {
+				panic(err)
+			}
+		}
+		n := len(r.regions)
+		fmt.Printf("Found %d records in state %s\n", n, r.state.Prefix())
 		m += n
-	}
+		if r.skipped > 0 {
+			skipped[r.state.Prefix()] = r.skipped
+		}
+	})
 	fmt.Printf("Found %d records overall\n", m)
+
+	if err := out.Close(); err != nil {
+		panic(err)
+	}
+
+	if lenient {
+		var k int
+		for _, state := range states {
+			if x := skipped[state.Prefix()]; x > 0 {
+				fmt.Printf("Skipped %d records in state %s\n", x, state.Prefix())
+				k += x
+			}
+		}
+		fmt.Printf("Skipped %d records overall\n", k)
+	}
+}
+
+// stateResult holds the regions of one state, along with the warnings
+// and the number of records skipped in lenient mode.
+type stateResult struct {
+	state    seglib.State
+	regions  []seglib.Region
+	warnings []string
+	skipped  int
+}
+
+// warn records a warning, to be printed when the state is written.
+func (r *stateResult) warn(format string, args ...interface{}) {
+	r.warnings = append(r.warnings, fmt.Sprintf(format, args...))
+}
+
+// collateStates collates the states using a pool of workers, and calls
+// write with the result for each state in the same order as states.  At
+// most 2*workers states are held in memory at once.
+func collateStates(states []seglib.State, workers int, write func(*stateResult)) {
+
+	done := make([]chan *stateResult, len(states))
+	for i := range done {
+		done[i] = make(chan *stateResult, 1)
+	}
+
+	// A worker may not start on a state until there is room for it
+	window := make(chan bool, 2*workers)
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for k := 0; k < workers; k++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				done[i] <- doState(states[i])
+			}
+		}()
+	}
+
+	go func() {
+		for i := range states {
+			window <- true
+			jobs <- i
+		}
+		close(jobs)
+	}()
+
+	for i := range states {
+		write(<-done[i])
+		<-window
+	}
+	wg.Wait()
+}
+
+// counts holds the population counts for one universe, either the total
+// population or the population 18 years and over.
+type counts struct {
+	totpop   int
+	hispanic int
+	nhwhite  int
+	nhblack  int
+	race     seglib.RacePop
 }
 
 type demorect struct {
-	logrecno  string
-	totpop    int
-	whiteonly int
-	blackonly int
+	logrecno string
+
+	// Tables P1 and P2
+	counts
+
+	// Tables P3 and P4, the voting age population
+	vap counts
+
+	// Table H1, total, occupied and vacant housing units
+	housing [3]int
+
+	// The raw records from segments 1 and 2, and the user-defined groups
+	// counted from them
+	rec1, rec2 []string
+	groups     seglib.GroupCounts
 }
 
-func (dr *demorect) parse2010(demorec []string) {
+// The column of H0010001 (total housing units) in segment 2
+const h1Total = 149
 
-	dr.logrecno = demorec[4]
+// The column of P0010003 (White alone) in segment 1, the remaining race
+// categories of table P1 follow it in seglib.Race order.
+const p1White = 7
+
+// The columns of table P2 in segment 1: P0020001 (total), P0020002
+// (Hispanic or Latino), P0020005 (not Hispanic, White alone) and P0020006
+// (not Hispanic, Black alone).  Table P4 has the same layout in segment 2.
+const (
+	p2Total    = 76
+	p2Hispanic = 77
+	p2NHWhite  = 80
+	p2NHBlack  = 81
+)
+
+// atoi reads column j of a record from a data segment, identifying the
+// column by name if it can't be read.
+func atoi(demorec []string, segment, j int) (int, error) {
+
+	if j >= len(demorec) {
+		err := fmt.Errorf("record has only %d fields", len(demorec))
+		return 0, &seglib.FieldError{Field: seglib.ColumnName(segment, j), Err: err}
+	}
+
+	x, err := strconv.Atoi(demorec[j])
+	if err != nil {
+		return 0, &seglib.FieldError{Field: seglib.ColumnName(segment, j), Value: demorec[j], Err: err}
+	}
+
+	return x, nil
+}
+
+// parse reads the counts from tables P1 and P2 in segment 1.  Tables P3
+// and P4 for the voting age population have the same layout in segment 2.
+func (c *counts) parse(demorec []string, segment int) error {
 
 	var err error
-	dr.totpop, err = strconv.Atoi(demorec[76])
+	for j := range c.race {
+		c.race[j], err = atoi(demorec, segment, p1White+j)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.totpop, err = atoi(demorec, segment, p2Total)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	dr.whiteonly, err = strconv.Atoi(demorec[80])
+	c.hispanic, err = atoi(demorec, segment, p2Hispanic)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	dr.blackonly, err = strconv.Atoi(demorec[81])
+	c.nhwhite, err = atoi(demorec, segment, p2NHWhite)
 	if err != nil {
-		panic(err)
+		return err
 	}
+
+	c.nhblack, err = atoi(demorec, segment, p2NHBlack)
+	return err
 }
 
-func (dr *demorect) parse2000(demorec []string) {
+func (dr *demorect) parse2010(demorec []string) error {
 
-	// It appears to be the same as 2010
-	dr.parse2010(demorec)
+	if len(demorec) < 5 {
+		return fmt.Errorf("record has only %d fields", len(demorec))
+	}
+	dr.logrecno = strings.TrimSpace(demorec[4])
+	dr.rec1 = demorec
+	return dr.counts.parse(demorec, 1)
 }
 
-type georect struct {
-	sumlevel   string
-	stateid    string
-	county     string
-	cousubPart string
-	tractPart  string
-	blkgrpPart string
-	logrecno   string
-	name       string
-	cbsa       string
-	lat        float64
-	lon        float64
-}
+// parseSeg2 reads the voting age and housing counts from a segment 2
+// record, which must be for the same logical record as the segment 1
+// record.
+func (dr *demorect) parseSeg2(demorec []string) error {
 
-func (gr *georect) parse2010(georec string) {
+	dr.rec2 = demorec
+	if err := dr.vap.parse(demorec, 2); err != nil {
+		return err
+	}
 
-	gr.sumlevel = georec[8 : 8+3]
-	gr.stateid = georec[27 : 27+2]
-	gr.county = georec[29 : 29+3]
-	gr.cousubPart = georec[36 : 36+5]
-	gr.tractPart = strings.TrimSpace(georec[54 : 54+6])
-	gr.blkgrpPart = strings.TrimSpace(georec[60 : 60+1])
-	gr.logrecno = georec[18 : 18+7]
-	gr.name = strings.TrimSpace(georec[226 : 226+90])
-	gr.cbsa = georec[112 : 112+5]
+	// H1 is only present from 2010 on
+	dr.housing = [3]int{}
+	if len(demorec) < h1Total+3 {
+		return nil
+	}
+	for j := range dr.housing {
+		var err error
+		dr.housing[j], err = atoi(demorec, 2, h1Total+j)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parse1990 reads one universe of counts from a 1990 record, starting at
+// byte pos.  The total population is followed by the non-Hispanic White
+// and Black populations, the five race categories, and the Hispanic
+// population.
+func (c *counts) parse1990(rec string, pos int) error {
 
 	var err error
-	gr.lat, err = strconv.ParseFloat(georec[336:336+11], 64)
-	if err != nil {
-		panic(err)
+	field := func(j int) int {
+		if err != nil {
+			return 0
+		}
+		a, b := pos+9*j, pos+9*(j+1)
+		if b > len(rec) {
+			err = fmt.Errorf("record has only %d bytes", len(rec))
+			return 0
+		}
+		x, e := strconv.Atoi(strings.TrimSpace(rec[a:b]))
+		if e != nil {
+			err = &seglib.FieldError{Field: fmt.Sprintf("bytes %d-%d", a, b), Value: rec[a:b], Err: e}
+		}
+		return x
 	}
-	gr.lon, err = strconv.ParseFloat(georec[347:347+12], 64)
-	if err != nil {
-		panic(err)
+
+	c.totpop = field(0)
+	c.nhwhite = field(1)
+	c.nhblack = field(2)
+
+	// There are five race categories in 1990, with Asian and Pacific
+	// Islander combined, and no multiracial category.
+	c.race = seglib.RacePop{}
+	for j, r := range []seglib.Race{seglib.White, seglib.Black, seglib.AmericanIndian, seglib.Asian, seglib.OtherRace} {
+		c.race[r] = field(3 + j)
+	}
+
+	c.hispanic = field(8)
+
+	return err
+}
+
+// parse1990 reads the counts from a 1990 record.  As in the later years
+// these are the total population and the non-Hispanic White and Black
+// populations, followed by the same counts for the voting age population.
+//
+// TODO: check the 1990 offsets against the codebook
+func (dr *demorect) parse1990(rec string) error {
+
+	if len(rec) < 18+7 {
+		return fmt.Errorf("record has only %d bytes", len(rec))
+	}
+	dr.logrecno = strings.TrimSpace(rec[18 : 18+7])
+	if err := dr.counts.parse1990(rec, 300); err != nil {
+		return err
 	}
+	return dr.vap.parse1990(rec, 381)
+}
+
+func (dr *demorect) parse2020(demorec []string) error {
+
+	// Segment 1 has the same columns as in 2010, but is pipe-delimited
+	return dr.parse2010(demorec)
 }
 
-func (gr *georect) parse2000(georec string) {
+func (dr *demorect) parse2000(demorec []string) error {
 
-	gr.sumlevel = georec[8 : 8+3]
-	gr.stateid = georec[29 : 29+2]
-	gr.county = georec[31 : 31+3]
-	gr.cousubPart = georec[36 : 36+5]
-	gr.tractPart = strings.TrimSpace(georec[55 : 55+6])
-	gr.blkgrpPart = strings.TrimSpace(georec[61 : 61+1])
-	gr.logrecno = georec[18 : 18+7]
-	gr.name = strings.TrimSpace(georec[200 : 200+90])
-	gr.cbsa = georec[106 : 106+4] // CMSA
+	// It appears to be the same as 2010
+	return dr.parse2010(demorec)
+}
 
-	var err error
-	gr.lat, err = strconv.ParseFloat(georec[310:310+9], 64)
+// openGz opens a gzip compressed file in the data directory.
+func openGz(fname string) io.Reader {
+
+	fid, err := os.Open(path.Join(dir, fname))
 	if err != nil {
 		panic(err)
 	}
-	gr.lon, err = strconv.ParseFloat(georec[319:319+10], 64)
+
+	gid, err := gzip.NewReader(fid)
 	if err != nil {
 		panic(err)
 	}
 
-	// No decimal place in the these files
-	gr.lat /= 1e6
-	gr.lon /= 1e6
+	return gid
 }
 
-func doState(state string) int {
+// inputFiles returns the names of the files that are read for a state.
+// In 1990 this is a single file, in the other years it is the geographic
+// header followed by data segments 1 and 2.
+func inputFiles(state string) []string {
 
-	var gfn string
 	switch year {
 	case 1990:
-		panic("invalid year")
+		return []string{fmt.Sprintf("%spl90.dat.gz", state)}
 	case 2000:
-		gfn = fmt.Sprintf("%sgeo.upl.gz", state)
-	case 2010:
-		gfn = fmt.Sprintf("%sgeo%4d.pl.gz", state, year)
+		return []string{
+			fmt.Sprintf("%sgeo.upl.gz", state),
+			fmt.Sprintf("%s00001.upl.gz", state),
+			fmt.Sprintf("%s00002.upl.gz", state),
+		}
+	case 2010, 2020:
+		return []string{
+			fmt.Sprintf("%sgeo%4d.pl.gz", state, year),
+			fmt.Sprintf("%s00001%4d.pl.gz", state, year),
+			fmt.Sprintf("%s00002%4d.pl.gz", state, year),
+		}
+	default:
+		panic("invalid year")
 	}
+}
 
-	pa := path.Join(dir, gfn)
-	geof, err := os.Open(pa)
-	if err != nil {
-		panic(err)
+// hashInputs returns the hashes of the files that are read for the
+// states, using a pool of workers.
+func hashInputs(states []seglib.State, workers int) []seglib.InputFile {
+
+	var fnames []string
+	for _, st := range states {
+		fnames = append(fnames, inputFiles(st.Prefix())...)
 	}
 
-	geoz, err := gzip.NewReader(geof)
-	if err != nil {
-		panic(err)
+	inputs := make([]seglib.InputFile, len(fnames))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for k := 0; k < workers; k++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				var err error
+				inputs[i], err = seglib.HashFile(path.Join(dir, fnames[i]))
+				if err != nil {
+					panic(err)
+				}
+			}
+		}()
 	}
 
-	var dfn string
-	switch year {
-	case 1990:
-		panic("invalid year")
-	case 2000:
-		dfn = fmt.Sprintf("%s00001.upl.gz", state)
-	case 2010:
-		dfn = fmt.Sprintf("%s00001%4d.pl.gz", state, year)
+	for i := range fnames {
+		jobs <- i
 	}
+	close(jobs)
+	wg.Wait()
 
-	pa = path.Join(dir, dfn)
-	demof, err := os.Open(pa)
+	return inputs
+}
+
+// recordReader returns a function that parses the next geographic and
+// demographic records for a state into grt and drt, returning false when
+// there are no more records.  A record that can't be parsed is reported
+// with a *seglib.RecordError, and reading can continue with the next
+// record.
+//
+// Only the records at the target summary level are returned, so errors
+// in the records at other levels are ignored.  Except in 1990, the data
+// segments are joined to the geographic header by logical record number,
+// so they need not be in the same order.  The data segment records that
+// can't be read are skipped and counted in res in lenient mode, otherwise
+// they cause a panic.
+func recordReader(state string, grt *seglib.GeoRecord, drt *demorect, res *stateResult) func() (bool, error) {
+
+	layout, err := seglib.GeoLayout(year)
 	if err != nil {
 		panic(err)
 	}
 
-	demoz, err := gzip.NewReader(demof)
-	if err != nil {
-		panic(err)
+	recErr := func(fname string, line int, err error) error {
+		return &seglib.RecordError{State: state, File: fname, Line: line, Err: err}
 	}
 
-	democsv := csv.NewReader(demoz)
-	geoscanner := bufio.NewScanner(geoz)
+	// A geographic record whose summary level can't be read can't be
+	// placed at any level, so it is skipped and counted in both strict and
+	// lenient mode.
+	badSumlevel := func(fname string, line int, err error) bool {
+		fe, ok := err.(*seglib.FieldError)
+		if !ok || fe.Field != "Sumlevel" {
+			return false
+		}
+		res.warn("Skipping record, the summary level can't be read: %v\n", recErr(fname, line, err))
+		res.skipped++
+		return true
+	}
 
-	var n int
-	grt := new(georect)
-	drt := new(demorect)
-	for {
-		demorec, err := democsv.Read()
-		if err == io.EOF {
-			break
-		} else if err != nil {
+	// In 1990 the counts are in the same fixed-width record as the
+	// geographic identifiers.
+	if year == 1990 {
+		fname := inputFiles(state)[0]
+		scanner := bufio.NewScanner(openGz(fname))
+		var line int
+		return func() (bool, error) {
+			for scanner.Scan() {
+				line++
+				rec := scanner.Text()
+				err := layout.Parse(rec, grt)
+				if badSumlevel(fname, line, err) || grt.Sumlevel != sumlevelCode {
+					continue
+				}
+				if err != nil {
+					return true, recErr(fname, line, err)
+				}
+				if err := drt.parse1990(rec); err != nil {
+					return true, recErr(fname, line, err)
+				}
+				if drt.logrecno != grt.Logrecno {
+					return true, recErr(fname, line, fmt.Errorf("record has logical record number %s, expected %s", drt.logrecno, grt.Logrecno))
+				}
+				return true, nil
+			}
+			if err := scanner.Err(); err != nil {
+				panic(err)
+			}
+			return false, nil
+		}
+	}
+
+	// Segment 1 has tables P1 and P2, segment 2 has P3 and P4
+	files := inputFiles(state)
+	gfn, dfn, dfn2 := files[0], files[1], files[2]
+
+	// The geographic records at the target summary level, in file order,
+	// along with any that can't be parsed
+	type geoEntry struct {
+		gr   seglib.GeoRecord
+		line int
+		err  error
+	}
+	var entries []geoEntry
+	keep := make(map[string]bool)
+	known := make(map[string]bool)
+	geoscanner := bufio.NewScanner(openGz(gfn))
+	for line := 1; geoscanner.Scan(); line++ {
+		var gr seglib.GeoRecord
+		err := layout.Parse(geoscanner.Text(), &gr)
+		if gr.Logrecno != "" {
+			known[gr.Logrecno] = true
+		}
+		switch {
+		case badSumlevel(gfn, line, err):
+		case gr.Sumlevel != sumlevelCode:
+			// Records at other levels are not used, even if they can't
+			// be parsed.
+		case err != nil:
+			entries = append(entries, geoEntry{line: line, err: err})
+		default:
+			entries = append(entries, geoEntry{gr: gr, line: line})
+			keep[gr.Logrecno] = true
+		}
+	}
+	if err := geoscanner.Err(); err != nil {
+		panic(err)
+	}
+
+	readSegment := func(fname string) *seglib.Segment {
+		r := csv.NewReader(openGz(fname))
+		if year == 2020 {
+			r.Comma = '|'
+		}
+		seg, err := seglib.ReadSegment(r, fname, keep, known)
+		if err != nil {
 			panic(err)
 		}
+		for _, err := range seg.Errors {
+			err.(*seglib.RecordError).State = state
+			if !lenient {
+				panic(err)
+			}
+			res.warn("Skipping record: %v\n", err)
+			res.skipped++
+		}
+		if len(seg.Extra) > 0 {
+			res.warn("%s: %d records in %s are not in %s\n", state, len(seg.Extra), fname, gfn)
+		}
+		return seg
+	}
+	seg1 := readSegment(dfn)
+	seg2 := readSegment(dfn2)
 
-		if !geoscanner.Scan() {
-			break
+	var i int
+	return func() (bool, error) {
+		if i >= len(entries) {
+			return false, nil
+		}
+		e := entries[i]
+		i++
+
+		if e.err != nil {
+			return true, recErr(gfn, e.line, e.err)
+		}
+		*grt = e.gr
+
+		lr := e.gr.Logrecno
+		rec1, ok := seg1.Records[lr]
+		if !ok {
+			return true, recErr(gfn, e.line, fmt.Errorf("logical record %s is not in %s", lr, dfn))
+		}
+		rec2, ok := seg2.Records[lr]
+		if !ok {
+			return true, recErr(gfn, e.line, fmt.Errorf("logical record %s is not in %s", lr, dfn2))
 		}
-		georec := geoscanner.Text()
 
+		var err error
 		switch year {
+		case 2020:
+			err = drt.parse2020(rec1.Fields)
 		case 2010:
-			drt.parse2010(demorec)
-			grt.parse2010(georec)
+			err = drt.parse2010(rec1.Fields)
 		case 2000:
-			drt.parse2000(demorec)
-			grt.parse2000(georec)
-		default:
-			panic("invalid year")
+			err = drt.parse2000(rec1.Fields)
+		}
+		if err != nil {
+			return true, recErr(dfn, rec1.Line, err)
+		}
+		if drt.logrecno != lr {
+			return true, recErr(dfn, rec1.Line, fmt.Errorf("record has logical record number %s, expected %s", drt.logrecno, lr))
 		}
 
-		switch sumlevel {
-		case seglib.CountySubdivision:
-			if grt.sumlevel != sumlevelCodes[0] {
-				continue
-			}
-		case seglib.Tract:
-			if grt.sumlevel != sumlevelCodes[1] {
-				continue
-			}
-		case seglib.BlockGroup:
-			if grt.sumlevel != sumlevelCodes[2] {
-				continue
+		if err := drt.parseSeg2(rec2.Fields); err != nil {
+			return true, recErr(dfn2, rec2.Line, err)
+		}
+
+		// Group columns may come from either segment, the column name in
+		// the error tells which.
+		if err := drt.countGroups(); err != nil {
+			return true, recErr(dfn, rec1.Line, err)
+		}
+
+		return true, nil
+	}
+}
+
+// countGroups counts the user-defined groups from the raw records.
+func (dr *demorect) countGroups() error {
+
+	dr.groups = nil
+	if len(groups) == 0 {
+		return nil
+	}
+
+	dr.groups = make(seglib.GroupCounts)
+	for i := range groups {
+		g := &groups[i]
+		x, err := g.Count(dr.rec1, dr.rec2)
+		if err != nil {
+			return err
+		}
+		dr.groups[g.Name] = x
+	}
+
+	return nil
+}
+
+// doState collates the regions of one state.  It only reads the global
+// settings, so states can be collated concurrently.
+func doState(st seglib.State) *stateResult {
+
+	state := st.Prefix()
+	res := &stateResult{state: st}
+	grt := new(seglib.GeoRecord)
+	drt := new(demorect)
+	next := recordReader(state, grt, drt, res)
+	for {
+		ok, err := next()
+		if !ok {
+			break
+		}
+		if err != nil {
+			if !lenient {
+				panic(err)
 			}
-		default:
-			panic("Unrecognized summary level\n")
+			res.warn("Skipping record: %v\n", err)
+			res.skipped++
+			continue
+		}
+
+		if grt.Sumlevel != sumlevelCode {
+			continue
+		}
+
+		// The records of levels that are not within states, such as the
+		// 2020 ZCTAs, may not have a state code.  They hold the part of
+		// the region in the state of the file.
+		if grt.StateId == "" {
+			grt.StateId = st.FIPS
 		}
 
-		var tract, blockgrp, cousub string
+		var tract, blockgrp, block, cousub string
 		switch sumlevel {
 		case seglib.CountySubdivision:
-			cousub = grt.stateid + grt.county + grt.cousubPart
+			cousub = grt.StateId + grt.County + grt.Cousub
 		case seglib.Tract:
-			tract = grt.stateid + grt.county + grt.tractPart
+			tract = grt.StateId + grt.County + grt.Tract
 		case seglib.BlockGroup:
-			blockgrp = grt.stateid + grt.county + grt.tractPart + grt.blkgrpPart
-		default:
-			panic("unknown sumlevel")
-		}
-
-		if grt.logrecno != drt.logrecno {
-			panic("Record number mismatch\n")
+			blockgrp = grt.StateId + grt.County + grt.Tract + grt.BlockGroup
+		case seglib.Block:
+			block = grt.StateId + grt.County + grt.Tract + grt.Block
 		}
 
 		s := seglib.Region{
 			State:        state,
-			StateId:      grt.stateid,
-			County:       grt.county,
+			StateId:      grt.StateId,
+			County:       grt.County,
 			Cousub:       cousub,
 			Tract:        tract,
 			BlockGroup:   blockgrp,
-			Name:         grt.name,
-			Type:         seglib.Tract,
-			CBSA:         grt.cbsa,
-			Location:     orb.Point{grt.lon, grt.lat},
+			Block:        block,
+			GeoId:        sumlevel.GEOID(grt),
+			Name:         grt.Name,
+			Type:         sumlevel,
+			CBSA:         grt.CBSA,
+			Location:     orb.Point{grt.Lon, grt.Lat},
 			TotalPop:     drt.totpop,
-			BlackOnlyPop: drt.blackonly,
-			WhiteOnlyPop: drt.whiteonly,
+			BlackOnlyPop: drt.nhblack,
+			WhiteOnlyPop: drt.nhwhite,
+			HispanicPop:  drt.hispanic,
+			NHWhitePop:   drt.nhwhite,
+			NHBlackPop:   drt.nhblack,
+			RacePop:      drt.race,
+
+			VAPTotalPop:     drt.vap.totpop,
+			VAPBlackOnlyPop: drt.vap.nhblack,
+			VAPWhiteOnlyPop: drt.vap.nhwhite,
+			VAPHispanicPop:  drt.vap.hispanic,
+			VAPNHWhitePop:   drt.vap.nhwhite,
+			VAPNHBlackPop:   drt.vap.nhblack,
+			VAPRacePop:      drt.vap.race,
+
+			HousingUnits:  drt.housing[0],
+			OccupiedUnits: drt.housing[1],
+			VacantUnits:   drt.housing[2],
+
+			Groups: drt.groups,
 		}
 
-		err = out.Encode(&s)
-		if err != nil {
-			panic(err)
-		}
-		n++
+		res.regions = append(res.regions, s)
 	}
 
-	return n
+	return res
 }
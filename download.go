@@ -4,36 +4,56 @@
 // 1990  ???
 // 2000  https://www.census.gov/prod/cen2000/doc/pl94-171.pdf
 // 2010  https://www.census.gov/prod/cen2010/doc/pl94-171.pdf
+// 2020  https://www2.census.gov/programs-surveys/decennial/2020/technical-documentation/complete-tech-docs/summary-file/2020Census_PL94_171Redistricting_StatesTechDoc_English.pdf
 
 package main
 
 import (
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
-	"os/exec"
 	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/kshedden/segregation/seglib"
 )
 
 const (
-	baseDir = "/dsi/stage/stage/cscar-census"
-
 	// The URLs for the data files
-	base1990 = "www2.census.gov/census_1990/????"
-	base2000 = "www2.census.gov/census_2000/datasets/redistricting_file--pl_94-171"
-	base2010 = "www2.census.gov/census_2010/01-Redistricting_File--PL_94-171/"
-)
+	// TODO: check the 1990 archive names against the census site
+	base1990 = "https://www2.census.gov/census_1990/pl94-171"
+	base2000 = "https://www2.census.gov/census_2000/datasets/redistricting_file--pl_94-171"
+	base2010 = "https://www2.census.gov/census_2010/01-Redistricting_File--PL_94-171"
+	base2020 = "https://www2.census.gov/programs-surveys/decennial/2020/data/01-Redistricting_File--PL_94-171"
 
-var (
-	// The data directory name
-	dir string
+	// The name of the download manifest, stored in the data directory
+	manifestName = "manifest.json"
 )
 
 func getStateFiles(year int, state string) ([]string, []string) {
 
 	switch year {
+	case 2020:
+		// Three data segments and a pipe-delimited geo header
+		zip := []string{fmt.Sprintf("%s%d.pl.zip", state, year)}
+		fi := []string{
+			fmt.Sprintf("%s00001%4d.pl", state, year),
+			fmt.Sprintf("%s00002%4d.pl", state, year),
+			fmt.Sprintf("%s00003%4d.pl", state, year),
+			fmt.Sprintf("%sgeo%4d.pl", state, year),
+		}
+		return zip, fi
 	case 2010:
 		zip := []string{fmt.Sprintf("%s%d.pl.zip", state, year)}
 		fi := []string{
@@ -55,83 +75,648 @@ func getStateFiles(year int, state string) ([]string, []string) {
 		}
 		return zip, fi
 	case 1990:
-		return nil, nil // TODO
+		// One fixed-width file holding the geography and the counts
+		zip := []string{fmt.Sprintf("%spl90.zip", state)}
+		fi := []string{fmt.Sprintf("%spl90.dat", state)}
+		return zip, fi
 	default:
 		panic("unknown year")
 	}
 }
 
-func main() {
+// manifestEntry records the size and SHA-256 digest of one file in the
+// data directory.
+type manifestEntry struct {
+	Size   int64
+	SHA256 string
+}
 
-	yearx := flag.Int("year", 2010, "year of census data to download")
-	flag.Parse()
-	year := *yearx
-	if year != 1990 && year != 2000 && year != 2010 {
-		panic("Invalid year")
+// manifest records every archive and extracted file that has been
+// completely written to the data directory.
+type manifest struct {
+	Year  int
+	Files map[string]manifestEntry
+
+	// Guards Files, since states are downloaded concurrently
+	mu sync.Mutex
+}
+
+func loadManifest(dir string, year int) (*manifest, error) {
+
+	m := &manifest{Year: year, Files: make(map[string]manifestEntry)}
+
+	fid, err := os.Open(path.Join(dir, manifestName))
+	if err != nil && os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
 	}
-	println(fmt.Sprintf("Downloading census data for year %d\n", year))
+	defer fid.Close()
 
-	var wwwbase string
-	switch year {
-	case 2010:
-		wwwbase = base2010
-	case 2000:
-		wwwbase = base2000
-	case 19990:
-		wwwbase = base1990
+	if err := json.NewDecoder(fid).Decode(m); err != nil {
+		return nil, fmt.Errorf("%s: %v", manifestName, err)
+	}
+	if m.Files == nil {
+		m.Files = make(map[string]manifestEntry)
+	}
+
+	return m, nil
+}
+
+// save writes the manifest to the data directory, replacing any
+// existing manifest only once the new one is complete.
+func (m *manifest) save(dir string) error {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pa := path.Join(dir, manifestName)
+	tmp := pa + ".part"
+	fid, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(fid)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		fid.Close()
+		return err
+	}
+
+	if err := fid.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, pa)
+}
+
+// hashFile returns the size and hex-encoded SHA-256 digest of a file.
+func hashFile(pa string) (manifestEntry, error) {
+
+	fid, err := os.Open(pa)
+	if err != nil {
+		return manifestEntry{}, err
+	}
+	defer fid.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, fid)
+	if err != nil {
+		return manifestEntry{}, err
+	}
+
+	return manifestEntry{Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// check reports whether the file name in the data directory matches its
+// manifest entry.  A nil error means that the file is intact.
+func (m *manifest) check(dir, name string) error {
+
+	m.mu.Lock()
+	want, ok := m.Files[name]
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%s: not in manifest", name)
+	}
+
+	got, err := hashFile(path.Join(dir, name))
+	if err != nil {
+		return err
+	}
+
+	if got.Size != want.Size {
+		return fmt.Errorf("%s: size is %d, expected %d", name, got.Size, want.Size)
+	}
+	if got.SHA256 != want.SHA256 {
+		return fmt.Errorf("%s: checksum mismatch", name)
+	}
+
+	return nil
+}
+
+// record adds the current size and digest of the file name to the
+// manifest.
+func (m *manifest) record(dir, name string) error {
+
+	e, err := hashFile(path.Join(dir, name))
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.Files[name] = e
+	m.mu.Unlock()
+
+	return nil
+}
+
+// has reports whether the file name is in the manifest.
+func (m *manifest) has(name string) bool {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.Files[name]
+
+	return ok
+}
+
+// names returns the sorted names of all files in the manifest.
+func (m *manifest) names() []string {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var names []string
+	for name := range m.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// downloader retrieves the census archives for one year and extracts the
+// files that we use.
+type downloader struct {
+
+	// The URL under which the per-state directories are found
+	baseURL string
+
+	// The local directory where the archives and extracted files are stored
+	dir string
+
+	year int
+
+	client *http.Client
+
+	// Records the files that have been completely downloaded or extracted
+	man *manifest
+
+	// The number of attempts made for each archive, and the delay before
+	// the first retry, which doubles with each subsequent retry
+	attempts int
+	backoff  time.Duration
+}
+
+// newClient returns an HTTP client that also understands file:// URLs, so
+// that archives can be read from a local mirror of the census tree.
+func newClient() *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
+	return &http.Client{Transport: t}
+}
+
+// sourceURL returns the URL of the tree holding the per-state directories.
+// src may be an http(s) or file URL, or the name of a local directory
+// that mirrors the census layout.
+func sourceURL(src string) (string, error) {
+
+	if strings.Contains(src, "://") {
+		return strings.TrimSuffix(src, "/"), nil
+	}
+
+	abs, err := filepath.Abs(src)
+	if err != nil {
+		return "", err
+	}
+	if fi, err := os.Stat(abs); err != nil {
+		return "", err
+	} else if !fi.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", abs)
+	}
+
+	return "file://" + filepath.ToSlash(abs), nil
+}
+
+// statusError is returned when the server responds with an unexpected
+// HTTP status.
+type statusError struct {
+	url    string
+	status int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("get %s: %d %s", e.url, e.status, http.StatusText(e.status))
+}
+
+// transient reports whether a failed download is worth retrying.  Server
+// errors and rate limiting are retried, as are network errors, but other
+// HTTP errors such as a missing file are not.
+func transient(err error) bool {
+
+	if se, ok := err.(*statusError); ok {
+		return se.status >= 500 || se.status == http.StatusTooManyRequests
+	}
+
+	return !os.IsNotExist(err) && !os.IsPermission(err)
+}
+
+// fetch retrieves a single file from url and writes it to dst.  The file is
+// written under a temporary name and only renamed to dst once it is complete,
+// so an interrupted download does not leave a truncated file behind.  If a
+// partial file from an earlier run exists, the download resumes where it
+// stopped using an HTTP Range request.
+func (d *downloader) fetch(url, dst string) error {
+
+	tmp := dst + ".part"
+
+	var offset int64
+	if fi, err := os.Stat(tmp); err == nil {
+		offset = fi.Size()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		fmt.Printf("Resuming %s at byte %d\n", path.Base(dst), offset)
+		flags |= os.O_APPEND
+	case offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The partial file may already have all the data, which is the
+		// case if its size matches the size given by the server.
+		var size int64
+		cr := resp.Header.Get("Content-Range")
+		if _, err := fmt.Sscanf(cr, "bytes */%d", &size); err == nil && size == offset {
+			return os.Rename(tmp, dst)
+		}
+		fmt.Printf("Discarding %s, its size is %d but the server has '%s'\n", path.Base(tmp), offset, cr)
+		if err := os.Remove(tmp); err != nil {
+			return err
+		}
+		return d.fetch(url, dst)
+	case resp.StatusCode == http.StatusOK:
+		// Either a fresh download, or the server ignored the range
+		flags |= os.O_TRUNC
 	default:
-		panic("invalid year")
+		return &statusError{url: url, status: resp.StatusCode}
+	}
+
+	fid, err := os.OpenFile(tmp, flags, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(fid, resp.Body); err != nil {
+		fid.Close()
+		return fmt.Errorf("get %s: %v", url, err)
+	}
+
+	if err := fid.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, dst)
+}
+
+// extract writes the members of the zip archive zipname whose names are
+// in want to the data directory as gzip compressed files.  The names of
+// the members that were found are returned.
+func (d *downloader) extract(zipname string, want map[string]bool) ([]string, error) {
+
+	zr, err := zip.OpenReader(path.Join(d.dir, zipname))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", zipname, err)
+	}
+	defer zr.Close()
+
+	var found []string
+	for _, f := range zr.File {
+
+		name := path.Base(f.Name)
+		if !want[name] {
+			continue
+		}
+
+		fmt.Printf("Extracting %s from %s\n", name, zipname)
+		if err := d.gzipMember(f, name); err != nil {
+			return nil, fmt.Errorf("%s: %v", zipname, err)
+		}
+		if err := d.man.record(d.dir, name+".gz"); err != nil {
+			return nil, err
+		}
+		found = append(found, name)
+	}
+
+	return found, nil
+}
+
+// gzipMember decompresses one zip member and writes it to the data
+// directory with gzip compression, as expected by collate.go.
+func (d *downloader) gzipMember(f *zip.File, name string) error {
+
+	rdr, err := f.Open()
+	if err != nil {
+		return err
 	}
+	defer rdr.Close()
 
-	dir = path.Join(baseDir, "redistricting-data", fmt.Sprintf("%4d", year))
+	dst := path.Join(d.dir, name+".gz")
+	tmp := dst + ".part"
+	fid, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	gid := gzip.NewWriter(fid)
+	if _, err := io.Copy(gid, rdr); err != nil {
+		gid.Close()
+		fid.Close()
+		return err
+	}
+
+	if err := gid.Close(); err != nil {
+		fid.Close()
+		return err
+	}
+
+	if err := fid.Close(); err != nil {
+		return err
+	}
 
-	for _, state := range seglib.States {
+	return os.Rename(tmp, dst)
+}
+
+// getState downloads the archives for one state, if we do not already have
+// them, and extracts the data files.  The names of the files that were
+// extracted are returned, even if an error occurs part way through.
+func (d *downloader) getState(state seglib.State) ([]string, error) {
 
-		// The names of all state archive files
-		zipnames, finames := getStateFiles(year, state[1])
+	// The names of all state archive files
+	zipnames, finames := getStateFiles(d.year, state.Prefix())
 
-		for _, zipname := range zipnames {
+	want := make(map[string]bool)
+	for _, f := range finames {
+		want[f] = true
+	}
 
-			// Check if we already have the archive
-			pa := path.Join(dir, zipname)
-			_, err := os.Stat(pa)
-			if err != nil && os.IsNotExist(err) {
+	var done []string
+	for _, zipname := range zipnames {
 
-				// Get the zip file if we don't already have it
-				fmt.Printf("Getting %s\n", zipname)
-				pa = path.Join(wwwbase, state[0], zipname)
-				cmds := []string{"wget", "https://" + pa, fmt.Sprintf("--directory-prefix=%s", dir)}
-				cmd := exec.Command(cmds[0], cmds[1:]...)
-				cmd.Stderr = os.Stderr
-				err = cmd.Run()
-				if err != nil {
-					panic(err)
-				}
-			} else if err != nil {
-				panic(err)
-			} else {
-				// Don't get the zip file if we already have it
+		if err := d.getArchive(state, zipname); err != nil {
+			return done, err
+		}
+
+		found, err := d.extract(zipname, want)
+		if err != nil {
+			return done, err
+		}
+		for _, f := range found {
+			delete(want, f)
+		}
+		done = append(done, found...)
+	}
+
+	if len(want) > 0 {
+		var missing []string
+		for f := range want {
+			missing = append(missing, f)
+		}
+		sort.Strings(missing)
+		return done, fmt.Errorf("files not found in archives for %s: %s", state.Name, strings.Join(missing, ", "))
+	}
+
+	return done, d.man.save(d.dir)
+}
+
+// getArchive makes sure that an intact copy of the archive zipname is in
+// the data directory.  An archive that is already present is kept only if
+// it matches the manifest, or if it is not in the manifest but can be read
+// as a zip file.
+func (d *downloader) getArchive(state seglib.State, zipname string) error {
+
+	pa := path.Join(d.dir, zipname)
+	_, err := os.Stat(pa)
+	switch {
+	case err == nil:
+		if d.man.has(zipname) {
+			err := d.man.check(d.dir, zipname)
+			if err == nil {
 				fmt.Printf("Skipping download of %s\n", zipname)
+				return nil
+			}
+			fmt.Printf("Discarding %v\n", err)
+		} else if zr, err := zip.OpenReader(pa); err == nil {
+			// Left by a run that predates the manifest
+			zr.Close()
+			fmt.Printf("Skipping download of %s\n", zipname)
+			return d.man.record(d.dir, zipname)
+		} else {
+			fmt.Printf("Discarding unreadable archive %s: %v\n", zipname, err)
+		}
+		if err := os.Remove(pa); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	url := strings.Join([]string{d.baseURL, state.Dir, zipname}, "/")
+	delay := d.backoff
+	for k := 1; ; k++ {
+		fmt.Printf("Getting %s\n", zipname)
+		err := d.fetch(url, pa)
+		if err == nil {
+			break
+		}
+		if k >= d.attempts || !transient(err) {
+			return err
+		}
+		fmt.Printf("Attempt %d for %s failed, retrying in %v: %v\n", k, zipname, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+
+	return d.man.record(d.dir, zipname)
+}
+
+// stateResult is the outcome of downloading the files for one state.
+type stateResult struct {
+	state seglib.State
+	files []string
+	err   error
+}
+
+// getStates downloads all states using a pool of workers.  The results
+// are returned in the same order as states.
+func (d *downloader) getStates(states []seglib.State, workers int) []stateResult {
+
+	results := make([]stateResult, len(states))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for k := 0; k < workers; k++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				files, err := d.getState(states[i])
+				results[i] = stateResult{state: states[i], files: files, err: err}
 			}
+		}()
+	}
 
-			// Unzip the zip file
-			fmt.Printf("Unzipping %s\n", zipname)
-			pa = path.Join(dir, zipname)
-			cmd := exec.Command("unzip", "-o", "-d", dir, pa)
-			cmd.Stderr = os.Stderr
-			if err := cmd.Run(); err != nil {
-				panic(err)
+	for i := range states {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// summarize prints the files obtained for each state and the errors for
+// the states that failed.  It returns the number of failed states.
+func summarize(results []stateResult) int {
+
+	var nfail int
+	fmt.Printf("\nSummary:\n")
+	for _, r := range results {
+		if r.err != nil {
+			nfail++
+			fmt.Printf("FAILED %-20s %v\n", r.state.Name, r.err)
+			if len(r.files) > 0 {
+				fmt.Printf("       %-20s extracted %s\n", "", strings.Join(r.files, ", "))
 			}
+			continue
 		}
+		fmt.Printf("OK     %-20s %s\n", r.state.Name, strings.Join(r.files, ", "))
+	}
+	fmt.Printf("%d states succeeded, %d failed\n", len(results)-nfail, nfail)
+
+	return nfail
+}
+
+// verify checks every file in the data directory against the manifest,
+// and checks that the extracted files for every state in states are
+// present.  The problems that were found are returned.
+func (d *downloader) verify(states []seglib.State) []string {
+
+	var problems []string
 
-		// gzip compress the files that we want
+	for _, name := range d.man.names() {
+		if err := d.man.check(d.dir, name); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	for _, state := range states {
+		_, finames := getStateFiles(d.year, state.Prefix())
 		for _, f := range finames {
-			pa := path.Join(dir, f)
-			cmd := exec.Command("gzip", "-f", pa)
-			cmd.Stderr = os.Stderr
-			if err := cmd.Run(); err != nil {
-				panic(err)
+			if !d.man.has(f + ".gz") {
+				problems = append(problems, fmt.Sprintf("%s: missing file %s.gz", state.Name, f))
 			}
 		}
 	}
+
+	return problems
+}
+
+func main() {
+
+	yearx := flag.Int("year", 2010, "year of census data to download")
+	baseurl := flag.String("baseurl", "", "URL or local directory mirroring the census data tree (default is www2.census.gov)")
+	verify := flag.Bool("verify", false, "Check the data directory against the manifest instead of downloading")
+	workers := flag.Int("workers", 4, "Number of states to download concurrently")
+	attempts := flag.Int("attempts", 5, "Number of attempts for each archive")
+	backoff := flag.Duration("backoff", 2*time.Second, "Delay before the first retry of a failed download")
+	pr := flag.Bool("pr", false, "Include Puerto Rico")
+	statesf := flag.String("states", "", "Comma-separated postal or FIPS codes of the states to download (default all)")
+	var cfg seglib.Config
+	cfg.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	if err := cfg.Resolve(); err != nil {
+		os.Stderr.WriteString(fmt.Sprintf("%v\n", err))
+		os.Exit(1)
+	}
+	year := *yearx
+	if year != 1990 && year != 2000 && year != 2010 && year != 2020 {
+		os.Stderr.WriteString(fmt.Sprintf("Invalid year %d\n", year))
+		os.Exit(1)
+	}
+	states, err := seglib.ParseStates(*statesf, *pr)
+	if err != nil {
+		os.Stderr.WriteString(fmt.Sprintf("%v\n", err))
+		os.Exit(1)
+	}
+	if *workers < 1 || *attempts < 1 {
+		os.Stderr.WriteString("workers and attempts must be positive\n")
+		os.Exit(1)
+	}
+	wwwbase := *baseurl
+	if wwwbase == "" {
+		switch year {
+		case 2020:
+			wwwbase = base2020
+		case 2010:
+			wwwbase = base2010
+		case 2000:
+			wwwbase = base2000
+		case 1990:
+			wwwbase = base1990
+		}
+	}
+
+	wwwbase, err = sourceURL(wwwbase)
+	if err != nil {
+		os.Stderr.WriteString(fmt.Sprintf("%v\n", err))
+		os.Exit(1)
+	}
+	fmt.Printf("Reading archives from %s\n", wwwbase)
+
+	d := &downloader{
+		baseURL:  wwwbase,
+		dir:      cfg.RedistrictingDir(year),
+		year:     year,
+		client:   newClient(),
+		attempts: *attempts,
+		backoff:  *backoff,
+	}
+
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		os.Stderr.WriteString(fmt.Sprintf("%v\n", err))
+		os.Exit(1)
+	}
+
+	d.man, err = loadManifest(d.dir, year)
+	if err != nil {
+		os.Stderr.WriteString(fmt.Sprintf("%v\n", err))
+		os.Exit(1)
+	}
+
+	if *verify {
+		fmt.Printf("Verifying census data for year %d in %s\n", year, d.dir)
+		problems := d.verify(states)
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			fmt.Printf("Found %d problems\n", len(problems))
+			os.Exit(1)
+		}
+		fmt.Printf("All %d files are intact\n", len(d.man.names()))
+		return
+	}
+
+	println(fmt.Sprintf("Downloading census data for year %d\n", year))
+
+	results := d.getStates(states, *workers)
+	if summarize(results) > 0 {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,596 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kshedden/segregation/seglib"
+)
+
+// makeZip returns a zip archive holding the given members.
+func makeZip(t *testing.T, members map[string]string) []byte {
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range members {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// readGzip returns the decompressed contents of a file written by
+// gzipMember.
+func readGzip(t *testing.T, pa string) string {
+
+	fid, err := os.Open(pa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fid.Close()
+
+	gid, err := gzip.NewReader(fid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+// michigan2010 is a stand-in for the 2010 Michigan archive.
+var michigan2010 = map[string]string{
+	"mi000012010.pl": "segment 1\n",
+	"mi000022010.pl": "segment 2\n",
+	"migeo2010.pl":   "geo\n",
+	"README":         "not extracted\n",
+}
+
+// hitCounter counts the requests for each path.
+type hitCounter struct {
+	mu   sync.Mutex
+	hits map[string]int
+}
+
+func (h *hitCounter) add(p string) {
+	h.mu.Lock()
+	h.hits[p]++
+	h.mu.Unlock()
+}
+
+func (h *hitCounter) get(p string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.hits[p]
+}
+
+// serveFiles serves the given files by path, and counts the requests
+// for each path.
+func serveFiles(t *testing.T, files map[string][]byte) (*httptest.Server, *hitCounter) {
+
+	hits := &hitCounter{hits: make(map[string]int)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.add(r.URL.Path)
+		b, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, path.Base(r.URL.Path), time.Time{}, bytes.NewReader(b))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, hits
+}
+
+func lookupState(t *testing.T, postal string) seglib.State {
+
+	state, ok := seglib.StateByPostal(postal)
+	if !ok {
+		t.Fatalf("no state %s", postal)
+	}
+
+	return state
+}
+
+func newTestDownloader(t *testing.T, baseURL string) *downloader {
+	return &downloader{
+		baseURL:  baseURL,
+		dir:      t.TempDir(),
+		year:     2010,
+		client:   http.DefaultClient,
+		man:      &manifest{Year: 2010, Files: make(map[string]manifestEntry)},
+		attempts: 1,
+	}
+}
+
+func TestFetch(t *testing.T) {
+
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	srv, _ := serveFiles(t, map[string][]byte{"/a.zip": data})
+	d := newTestDownloader(t, srv.URL)
+
+	dst := path.Join(d.dir, "a.zip")
+	if err := d.fetch(srv.URL+"/a.zip", dst); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Errorf("got %d bytes, expected %d", len(b), len(data))
+	}
+	if _, err := os.Stat(dst + ".part"); !os.IsNotExist(err) {
+		t.Errorf("partial file was left behind")
+	}
+
+	// A missing file is an error, and nothing is written
+	dst = path.Join(d.dir, "b.zip")
+	if err := d.fetch(srv.URL+"/b.zip", dst); err == nil {
+		t.Errorf("no error for a missing file")
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("file written for a failed download")
+	}
+}
+
+func TestGetState(t *testing.T) {
+
+	srv, hits := serveFiles(t, map[string][]byte{
+		"/Michigan/mi2010.pl.zip": makeZip(t, michigan2010),
+	})
+	d := newTestDownloader(t, srv.URL)
+
+	state := lookupState(t, "MI")
+	if _, err := d.getState(state); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"mi000012010.pl", "mi000022010.pl", "migeo2010.pl"} {
+		if got := readGzip(t, path.Join(d.dir, name+".gz")); got != michigan2010[name] {
+			t.Errorf("%s: got %q, expected %q", name, got, michigan2010[name])
+		}
+	}
+	if _, err := os.Stat(path.Join(d.dir, "README.gz")); !os.IsNotExist(err) {
+		t.Errorf("extracted a file that is not used")
+	}
+
+	// The archive is not downloaded again
+	if _, err := d.getState(state); err != nil {
+		t.Fatal(err)
+	}
+	if n := hits.get("/Michigan/mi2010.pl.zip"); n != 1 {
+		t.Errorf("archive was requested %d times", n)
+	}
+
+	// An archive without the expected files
+	srv, _ = serveFiles(t, map[string][]byte{
+		"/Ohio/oh2010.pl.zip": makeZip(t, map[string]string{"oh000012010.pl": ""}),
+	})
+	d.baseURL = srv.URL
+	if _, err := d.getState(lookupState(t, "OH")); err == nil {
+		t.Errorf("no error for an incomplete archive")
+	}
+}
+
+func TestFetchResume(t *testing.T) {
+
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	srv, _ := serveFiles(t, map[string][]byte{"/a.zip": data})
+	d := newTestDownloader(t, srv.URL)
+
+	// A partial file left by an interrupted run
+	dst := path.Join(d.dir, "a.zip")
+	if err := os.WriteFile(dst+".part", data[:3000], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var ranges []string
+	d.client = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+
+	if err := d.fetch(srv.URL+"/a.zip", dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=3000-" {
+		t.Errorf("got ranges %q, expected only bytes=3000-", ranges)
+	}
+	if b, err := os.ReadFile(dst); err != nil || !bytes.Equal(b, data) {
+		t.Errorf("resumed file does not match: %v", err)
+	}
+}
+
+func TestFetchNoRange(t *testing.T) {
+
+	// A server that ignores the range sends the whole file
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer srv.Close()
+	d := newTestDownloader(t, srv.URL)
+
+	dst := path.Join(d.dir, "a.zip")
+	if err := os.WriteFile(dst+".part", data[:3000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.fetch(srv.URL+"/a.zip", dst); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(dst); err != nil || !bytes.Equal(b, data) {
+		t.Errorf("got %d bytes, expected %d", len(b), len(data))
+	}
+}
+
+func TestFetchComplete(t *testing.T) {
+
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+
+	for _, tc := range []struct {
+		part []byte
+		hits int
+	}{
+		// The partial file already has all the data, so the server
+		// responds that the range cannot be satisfied
+		{data, 1},
+
+		// The partial file is longer than the file on the server, so it
+		// is discarded and the file is downloaded again
+		{append(bytes.Repeat([]byte("x"), len(data)), '!'), 2},
+	} {
+		srv, hits := serveFiles(t, map[string][]byte{"/a.zip": data})
+		d := newTestDownloader(t, srv.URL)
+
+		dst := path.Join(d.dir, "a.zip")
+		if err := os.WriteFile(dst+".part", tc.part, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.fetch(srv.URL+"/a.zip", dst); err != nil {
+			t.Fatal(err)
+		}
+		if b, err := os.ReadFile(dst); err != nil || !bytes.Equal(b, data) {
+			t.Errorf("got %d bytes, expected %d", len(b), len(data))
+		}
+		if n := hits.get("/a.zip"); n != tc.hits {
+			t.Errorf("file was requested %d times, expected %d", n, tc.hits)
+		}
+	}
+
+	// A server that does not give the size of the file
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+	d := newTestDownloader(t, srv.URL)
+
+	dst := path.Join(d.dir, "a.zip")
+	if err := os.WriteFile(dst+".part", data[:10], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.fetch(srv.URL+"/a.zip", dst); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(dst); err != nil || !bytes.Equal(b, data) {
+		t.Errorf("got %d bytes, expected %d", len(b), len(data))
+	}
+}
+
+// roundTripFunc allows a function to be used as an http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// corrupt changes the first byte of a file, keeping its size.
+func corrupt(t *testing.T, pa string) {
+
+	b, err := os.ReadFile(pa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[0]++
+	if err := os.WriteFile(pa, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+
+	srv, hits := serveFiles(t, map[string][]byte{
+		"/Michigan/mi2010.pl.zip": makeZip(t, michigan2010),
+	})
+	d := newTestDownloader(t, srv.URL)
+	state := lookupState(t, "MI")
+
+	verify := func() []string {
+		return d.verify([]seglib.State{state})
+	}
+
+	if _, err := d.getState(state); err != nil {
+		t.Fatal(err)
+	}
+	if p := verify(); len(p) != 0 {
+		t.Fatalf("problems after download: %v", p)
+	}
+
+	// Damage the archive and one of the extracted files
+	corrupt(t, path.Join(d.dir, "mi2010.pl.zip"))
+	if err := os.Truncate(path.Join(d.dir, "migeo2010.pl.gz"), 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new run reads the saved manifest
+	var err error
+	d.man, err = loadManifest(d.dir, 2010)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := verify()
+	if len(p) != 2 || p[0] != "mi2010.pl.zip: checksum mismatch" || !strings.HasPrefix(p[1], "migeo2010.pl.gz: size is 10") {
+		t.Fatalf("got problems %q", p)
+	}
+
+	// Running again downloads the archive and extracts the files
+	if _, err := d.getState(state); err != nil {
+		t.Fatal(err)
+	}
+	if n := hits.get("/Michigan/mi2010.pl.zip"); n != 2 {
+		t.Errorf("archive was requested %d times, expected 2", n)
+	}
+	if p := verify(); len(p) != 0 {
+		t.Errorf("problems after download: %v", p)
+	}
+	if got := readGzip(t, path.Join(d.dir, "migeo2010.pl.gz")); got != michigan2010["migeo2010.pl"] {
+		t.Errorf("got %q, expected %q", got, michigan2010["migeo2010.pl"])
+	}
+}
+
+func TestTransient(t *testing.T) {
+
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{&statusError{url: "x", status: http.StatusInternalServerError}, true},
+		{&statusError{url: "x", status: http.StatusServiceUnavailable}, true},
+		{&statusError{url: "x", status: http.StatusTooManyRequests}, true},
+		{&statusError{url: "x", status: http.StatusNotFound}, false},
+		{&statusError{url: "x", status: http.StatusForbidden}, false},
+		{&url.Error{Op: "Get", URL: "x", Err: errors.New("connection reset by peer")}, true},
+		{fmt.Errorf("get x: %v", io.ErrUnexpectedEOF), true},
+		{&os.PathError{Op: "open", Path: "x", Err: os.ErrNotExist}, false},
+		{&os.PathError{Op: "open", Path: "x", Err: os.ErrPermission}, false},
+	} {
+		if got := transient(tc.err); got != tc.want {
+			t.Errorf("transient(%v) is %t, expected %t", tc.err, got, tc.want)
+		}
+	}
+}
+
+// flakyServer serves data after failing the first nfail requests with
+// the given status.
+func flakyServer(t *testing.T, data []byte, nfail, status int) (*httptest.Server, *hitCounter) {
+
+	hits := &hitCounter{hits: make(map[string]int)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.add(r.URL.Path)
+		if hits.get(r.URL.Path) <= nfail {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, hits
+}
+
+func TestRetry(t *testing.T) {
+
+	data := makeZip(t, michigan2010)
+	state := lookupState(t, "MI")
+	const archive = "/Michigan/mi2010.pl.zip"
+
+	for _, tc := range []struct {
+		nfail    int
+		status   int
+		attempts int
+		hits     int
+		ok       bool
+	}{
+		// A server error followed by success
+		{1, http.StatusServiceUnavailable, 3, 2, true},
+		{2, http.StatusTooManyRequests, 3, 3, true},
+
+		// Give up after the allowed number of attempts
+		{5, http.StatusServiceUnavailable, 3, 3, false},
+		{1, http.StatusServiceUnavailable, 1, 1, false},
+
+		// A missing file is not retried
+		{5, http.StatusNotFound, 3, 1, false},
+	} {
+		srv, hits := flakyServer(t, data, tc.nfail, tc.status)
+		d := newTestDownloader(t, srv.URL)
+		d.attempts = tc.attempts
+		d.backoff = time.Millisecond
+
+		err := d.getArchive(state, "mi2010.pl.zip")
+		if n := hits.get(archive); n != tc.hits {
+			t.Errorf("%+v: got %d requests, expected %d", tc, n, tc.hits)
+		}
+		if tc.ok {
+			if err != nil {
+				t.Errorf("%+v: %v", tc, err)
+			} else if b, err := os.ReadFile(path.Join(d.dir, "mi2010.pl.zip")); err != nil || !bytes.Equal(b, data) {
+				t.Errorf("%+v: archive does not match", tc)
+			}
+			continue
+		}
+		var se *statusError
+		if !errors.As(err, &se) || se.status != tc.status {
+			t.Errorf("%+v: got %v, expected status %d", tc, err, tc.status)
+		}
+	}
+}
+
+func TestGetStates(t *testing.T) {
+
+	srv, _ := serveFiles(t, map[string][]byte{
+		"/Michigan/mi2010.pl.zip": makeZip(t, michigan2010),
+		"/Indiana/in2010.pl.zip": makeZip(t, map[string]string{
+			"in000012010.pl": "", "in000022010.pl": "", "ingeo2010.pl": "",
+		}),
+	})
+	d := newTestDownloader(t, srv.URL)
+
+	// Ohio is missing from the server
+	states := []seglib.State{lookupState(t, "MI"), lookupState(t, "OH"), lookupState(t, "IN")}
+	results := d.getStates(states, 2)
+
+	if len(results) != len(states) {
+		t.Fatalf("got %d results, expected %d", len(results), len(states))
+	}
+	for i, r := range results {
+		if r.state.Postal != states[i].Postal {
+			t.Errorf("result %d is for %s, expected %s", i, r.state, states[i])
+		}
+	}
+	if results[0].err != nil || results[2].err != nil {
+		t.Errorf("unexpected errors %v, %v", results[0].err, results[2].err)
+	}
+	if len(results[0].files) != 3 || len(results[2].files) != 3 {
+		t.Errorf("got files %v and %v", results[0].files, results[2].files)
+	}
+
+	var se *statusError
+	if !errors.As(results[1].err, &se) || se.status != http.StatusNotFound {
+		t.Errorf("got %v for Ohio, expected a missing file", results[1].err)
+	}
+
+	if nfail := summarize(results); nfail != 1 {
+		t.Errorf("got %d failures, expected 1", nfail)
+	}
+}
+
+func TestSourceURL(t *testing.T) {
+
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "a.zip")
+	if err := os.WriteFile(fname, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		src  string
+		want string
+	}{
+		{"https://www2.census.gov/census_2010/", "https://www2.census.gov/census_2010"},
+		{"file:///data/census", "file:///data/census"},
+		{dir, "file://" + filepath.ToSlash(dir)},
+	} {
+		got, err := sourceURL(tc.src)
+		if err != nil {
+			t.Errorf("%s: %v", tc.src, err)
+		} else if got != tc.want {
+			t.Errorf("%s: got %s, expected %s", tc.src, got, tc.want)
+		}
+	}
+
+	for _, src := range []string{fname, filepath.Join(dir, "missing")} {
+		if _, err := sourceURL(src); err == nil {
+			t.Errorf("%s: no error", src)
+		}
+	}
+}
+
+func TestLocalSource(t *testing.T) {
+
+	// A local mirror of the census tree
+	mirror := t.TempDir()
+	data := makeZip(t, michigan2010)
+	if err := os.Mkdir(filepath.Join(mirror, "Michigan"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(mirror, "Michigan", "mi2010.pl.zip"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	baseURL, err := sourceURL(mirror)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, src := range []string{mirror, baseURL} {
+
+		base, err := sourceURL(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := newTestDownloader(t, base)
+		d.client = newClient()
+
+		files, err := d.getState(lookupState(t, "MI"))
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		if len(files) != 3 {
+			t.Errorf("%s: got files %v", src, files)
+		}
+		if p := d.verify([]seglib.State{lookupState(t, "MI")}); len(p) != 0 {
+			t.Errorf("%s: problems after download: %v", src, p)
+		}
+
+		// A partial file is resumed from the mirror
+		dst := path.Join(d.dir, "a.zip")
+		if err := os.WriteFile(dst+".part", data[:100], 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.fetch(base+"/Michigan/mi2010.pl.zip", dst); err != nil {
+			t.Fatal(err)
+		}
+		if b, err := os.ReadFile(dst); err != nil || !bytes.Equal(b, data) {
+			t.Errorf("%s: resumed file does not match", src)
+		}
+
+		// A file missing from the mirror is not retried
+		var se *statusError
+		_, err = d.getState(lookupState(t, "OH"))
+		if !errors.As(err, &se) || se.status != http.StatusNotFound || transient(err) {
+			t.Errorf("%s: got %v, expected a missing file", src, err)
+		}
+	}
+}
@@ -3,53 +3,120 @@ package main
 import (
 	"compress/gzip"
 	"encoding/csv"
-	"encoding/gob"
+	"flag"
 	"fmt"
-	"io"
 	"os"
 	"strings"
 
 	"github.com/kshedden/segregation/seglib"
+	"github.com/kshedden/segregation/seglib/regionparquet"
 )
 
 func main() {
 
-	inName := os.Args[1]
+	statesf := flag.String("states", "", "Comma-separated postal or FIPS codes of the states to write (default all)")
+	format := flag.String("format", "csv", "Output format, 'csv' for gzipped CSV or 'parquet'")
+	var cfg seglib.Config
+	cfg.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	if err := cfg.Resolve(); err != nil {
+		panic(err)
+	}
+
+	var keep map[string]bool
+	if *statesf != "" {
+		states, err := seglib.ParseStates(*statesf, true)
+		if err != nil {
+			panic(err)
+		}
+		keep = seglib.FIPSSet(states, false)
+	}
+
+	inName := cfg.OutPath(flag.Arg(0))
 	if !strings.HasSuffix(inName, ".gob.gz") {
 		panic("Invalid input file\n")
 	}
 	fmt.Printf("Reading regions from '%s'\n", inName)
 
-	outName := strings.Replace(inName, ".gob.gz", ".csv.gz", 1)
+	var ext string
+	switch *format {
+	case "csv":
+		ext = ".csv.gz"
+	case "parquet":
+		ext = ".parquet"
+	default:
+		panic(fmt.Sprintf("Unknown format '%s'\n", *format))
+	}
+
+	outName := strings.Replace(inName, ".gob.gz", ext, 1)
 	if outName == inName {
 		panic("Invalid input file\n")
 	}
 	fmt.Printf("Writing regions to '%s'\n", outName)
 
-	outf, err := os.Create(outName)
+	rr, err := seglib.OpenRegions(inName)
 	if err != nil {
 		panic(err)
 	}
-	defer outf.Close()
+	defer rr.Close()
+	if keep != nil {
+		rr.Filter(seglib.StateFilter(keep))
+	}
 
-	outg := gzip.NewWriter(outf)
-	defer outg.Close()
+	// The user-defined groups are the same in every record, so they are
+	// taken from the first one.
+	ok := rr.Next()
+	var groupNames []string
+	if ok {
+		groupNames = seglib.GroupNames(rr.Region().Groups)
+	}
 
-	outw := csv.NewWriter(outg)
-	defer outw.Flush()
+	if *format == "parquet" {
+		writeParquet(outName, rr, ok, groupNames)
+	} else {
+		writeCSV(outName, rr, ok, groupNames)
+	}
+}
 
-	inf, err := os.Open(inName)
+// writeParquet writes the regions to a Parquet file with a column for
+// every field.  If ok is true the reader is positioned at the first
+// region.
+func writeParquet(outName string, rr *seglib.RegionReader, ok bool, groupNames []string) {
+
+	pw, err := regionparquet.Create(outName, rr.Header(), groupNames)
 	if err != nil {
 		panic(err)
 	}
-	defer inf.Close()
 
-	ing, err := gzip.NewReader(inf)
+	for ; ok; ok = rr.Next() {
+		if err := pw.Write(rr.Region()); err != nil {
+			panic(err)
+		}
+	}
+	if err := rr.Err(); err != nil {
+		panic(err)
+	}
+
+	if err := pw.Close(); err != nil {
+		panic(err)
+	}
+}
+
+// writeCSV writes a selection of the fields to a gzipped CSV file.  If ok
+// is true the reader is positioned at the first region.
+func writeCSV(outName string, rr *seglib.RegionReader, ok bool, groupNames []string) {
+
+	outf, err := os.Create(outName)
 	if err != nil {
 		panic(err)
 	}
+	defer outf.Close()
+
+	outg := gzip.NewWriter(outf)
+	defer outg.Close()
 
-	dec := gob.NewDecoder(ing)
+	outw := csv.NewWriter(outg)
+	defer outw.Flush()
 
 	// Write out the header
 	head := []string{
@@ -62,20 +129,36 @@ func main() {
 		"BODissimilarity", "WODissimilarity", "BODissimilarityResid", "WODissimilarityResid",
 		"Neighbors", "RegionPop", "RegionRadius",
 	}
+
+	head = append(head, "HispanicPop", "NHWhitePop", "NHBlackPop", "CBSAHispanicPop", "PCBSAHispanicPop")
+	head = append(head, "VAPTotalPop", "VAPBlackOnlyPop", "VAPWhiteOnlyPop", "VAPHispanicPop")
+	head = append(head, "HousingUnits", "OccupiedUnits", "VacantUnits", "VacancyRate", "BlackIsolationHU", "WhiteIsolationHU")
+	head = append(head, "Block", "GeoId")
+	head = append(head, "VAPNHWhitePop", "VAPNHBlackPop", "HispanicIsolation", "HODissimilarity")
+
+	// The race counts from table P1 for the region, CBSA and pseudo-CBSA,
+	// and from table P3 for the voting age population
+	for _, pfx := range []string{"", "CBSA", "PCBSA", "VAP"} {
+		for _, rn := range seglib.RaceNames {
+			head = append(head, pfx+rn+"Pop")
+		}
+	}
+
+	// The count and measures for each user-defined group, prefixed so
+	// that they can't clash with the columns above
+	for _, g := range groupNames {
+		g = "Group" + g
+		head = append(head, g+"Pop", "CBSA"+g+"Pop", "PCBSA"+g+"Pop", g+"Isolation", g+"Dissimilarity")
+	}
+
 	err = outw.Write(head)
 	if err != nil {
 		panic(err)
 	}
 
 	cr := make([]string, len(head))
-	for {
-		var r seglib.Region
-		err := dec.Decode(&r)
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			panic(err)
-		}
+	for ; ok; ok = rr.Next() {
+		r := rr.Region()
 
 		cr[0] = r.State
 		cr[1] = r.StateId
@@ -109,6 +192,42 @@ func main() {
 		cr[29] = fmt.Sprintf("%d", r.Neighbors)
 		cr[30] = fmt.Sprintf("%d", r.RegionPop)
 		cr[31] = fmt.Sprintf("%.2f", r.RegionRadius)
+		cr[32] = fmt.Sprintf("%d", r.HispanicPop)
+		cr[33] = fmt.Sprintf("%d", r.NHWhitePop)
+		cr[34] = fmt.Sprintf("%d", r.NHBlackPop)
+		cr[35] = fmt.Sprintf("%d", r.CBSAHispanicPop)
+		cr[36] = fmt.Sprintf("%d", r.PCBSAHispanicPop)
+		cr[37] = fmt.Sprintf("%d", r.VAPTotalPop)
+		cr[38] = fmt.Sprintf("%d", r.VAPBlackOnlyPop)
+		cr[39] = fmt.Sprintf("%d", r.VAPWhiteOnlyPop)
+		cr[40] = fmt.Sprintf("%d", r.VAPHispanicPop)
+		cr[41] = fmt.Sprintf("%d", r.HousingUnits)
+		cr[42] = fmt.Sprintf("%d", r.OccupiedUnits)
+		cr[43] = fmt.Sprintf("%d", r.VacantUnits)
+		cr[44] = fmt.Sprintf("%.6f", r.VacancyRate)
+		cr[45] = fmt.Sprintf("%.6f", r.BlackIsolationHU)
+		cr[46] = fmt.Sprintf("%.6f", r.WhiteIsolationHU)
+		cr[47] = r.Block
+		cr[48] = r.GeoId
+		cr[49] = fmt.Sprintf("%d", r.VAPNHWhitePop)
+		cr[50] = fmt.Sprintf("%d", r.VAPNHBlackPop)
+		cr[51] = fmt.Sprintf("%.6f", r.HispanicIsolation)
+		cr[52] = fmt.Sprintf("%.6f", r.HODissimilarity)
+		j := 53
+		for _, rp := range []seglib.RacePop{r.RacePop, r.CBSARacePop, r.PCBSARacePop, r.VAPRacePop} {
+			for _, x := range rp {
+				cr[j] = fmt.Sprintf("%d", x)
+				j++
+			}
+		}
+		for _, g := range groupNames {
+			cr[j] = fmt.Sprintf("%d", r.Groups[g])
+			cr[j+1] = fmt.Sprintf("%d", r.CBSAGroups[g])
+			cr[j+2] = fmt.Sprintf("%d", r.PCBSAGroups[g])
+			cr[j+3] = fmt.Sprintf("%.6f", r.GroupIsolation[g])
+			cr[j+4] = fmt.Sprintf("%.6f", r.GroupDissimilarity[g])
+			j += 5
+		}
 
 		if len(head) != len(cr) {
 			panic("len(head) ! = len(cr)\n")
@@ -119,4 +238,7 @@ func main() {
 			panic(err)
 		}
 	}
+	if err := rr.Err(); err != nil {
+		panic(err)
+	}
 }
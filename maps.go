@@ -1,13 +1,8 @@
 package main
 
 import (
-	"compress/gzip"
-	"encoding/gob"
 	"flag"
 	"fmt"
-	"io"
-	"os"
-	"path"
 	"strconv"
 	"strings"
 
@@ -21,8 +16,10 @@ import (
 )
 
 var (
-	// The census region boundaries
-	shapefile = "gz_2010_##_!!!_00_500k.shp"
+	cfg seglib.Config
+
+	// The year of the census region boundaries
+	year = 2010
 
 	// The segregation metrics
 	segmetricsfile = "segregation_!!!!!_2010#####.gob.gz"
@@ -81,37 +78,22 @@ func MustParseHex(s string) colorful.Color {
 	return c
 }
 
-func getSeg(fname string, regtype seglib.RegionType) map[string]*seglib.Region {
-
-	inf, err := os.Open(fname)
-	if err != nil {
-		panic(err)
-	}
-	defer inf.Close()
+func getSeg(fname string) map[string]*seglib.Region {
 
-	ing, err := gzip.NewReader(inf)
+	rr, err := seglib.OpenRegions(fname)
 	if err != nil {
 		panic(err)
 	}
+	defer rr.Close()
+	rr.Filter(seglib.BoundFilter(bbox))
 
 	regions := make(map[string]*seglib.Region)
-	dec := gob.NewDecoder(ing)
 	first := true
-	for {
-		var r seglib.Region
-		err := dec.Decode(&r)
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			panic(err)
-		}
-
-		if !bbox.Contains(r.Location) {
-			continue
-		}
+	for rr.Next() {
+		r := rr.Region()
 
 		// Update the attribute range
-		v := attrf(&r)
+		v := attrf(r)
 		if first {
 			mina, maxa = v, v
 			first = false
@@ -124,18 +106,10 @@ func getSeg(fname string, regtype seglib.RegionType) map[string]*seglib.Region {
 			}
 		}
 
-		var id string
-		switch regtype {
-		case seglib.CountySubdivision:
-			id = r.Cousub
-		case seglib.Tract:
-			id = r.Tract
-		case seglib.BlockGroup:
-			id = r.BlockGroup
-		default:
-			panic("unknown region")
-		}
-		regions[id] = &r
+		regions[r.GeoId] = r
+	}
+	if err := rr.Err(); err != nil {
+		panic(err)
 	}
 
 	if scale01 {
@@ -192,24 +166,21 @@ func main() {
 	outfile := flag.String("outfile", "", "Output file name")
 	bboxf := flag.String("bbox", "", "Bounding box")
 	buffer := flag.Int("buffer", 0, "Buffer population")
-	state := flag.String("state", "", "State")
-	region := flag.String("region", "", "cousub, tract, or blockgroup")
+	statef := flag.String("state", "", "State postal code, FIPS code or name")
+	region := flag.String("region", "", "Summary level, one of "+strings.Join(seglib.RegionTypeNames(), ", "))
+	cfg.RegisterFlags(flag.CommandLine)
 	flag.Parse()
+	if err := cfg.Resolve(); err != nil {
+		panic(err)
+	}
 
-	var regtype seglib.RegionType
-	switch *region {
-	case "cousub":
-		if *buffer != 0 {
-			msg := "'buffer' must be 0 when region is 'cousub'"
-			panic(msg)
-		}
-		regtype = seglib.CountySubdivision
-	case "tract":
-		regtype = seglib.Tract
-	case "blockgroup":
-		regtype = seglib.BlockGroup
-	default:
-		panic("region must be one of 'cousub', 'tract', or 'blockgroup'")
+	regtype, err := seglib.ParseRegionType(*region)
+	if err != nil {
+		panic(err)
+	}
+	if regtype == seglib.CountySubdivision && *buffer != 0 {
+		msg := "'buffer' must be 0 when region is 'cousub'"
+		panic(msg)
 	}
 
 	scale01 = false
@@ -236,20 +207,23 @@ func main() {
 	case "WODissimilarity":
 		attrf = func(r *seglib.Region) float64 { return r.WODissimilarity }
 		scale01 = true
+	case "HispanicIsolation":
+		attrf = func(r *seglib.Region) float64 { return r.HispanicIsolation }
+		scale01 = true
+	case "HODissimilarity":
+		attrf = func(r *seglib.Region) float64 { return r.HODissimilarity }
+		scale01 = true
 	default:
 		panic(fmt.Sprintf("Unknown attribute '%s'", *aname))
 	}
 
-	shapefile = strings.Replace(shapefile, "##", *state, 1)
-	switch regtype {
-	case seglib.CountySubdivision:
-		shapefile = strings.Replace(shapefile, "!!!", "060", 1)
-	case seglib.Tract:
-		shapefile = strings.Replace(shapefile, "!!!", "140", 1)
-	case seglib.BlockGroup:
-		shapefile = strings.Replace(shapefile, "!!!", "150", 1)
-	default:
-		panic("Unkown region type")
+	state, err := seglib.LookupState(*statef)
+	if err != nil {
+		panic(err)
+	}
+	shapefile, shapeAttrs, err := regtype.ShapeFile(year, state.FIPS)
+	if err != nil {
+		panic(err)
 	}
 
 	parseBbox(*bboxf)
@@ -261,18 +235,15 @@ func main() {
 	yf = 1000 / (bbox.Max[1] - bbox.Min[1])
 
 	segmetricsfile = strings.Replace(segmetricsfile, "!!!!!", *region, 1)
-	switch regtype {
-	case seglib.CountySubdivision:
+	if regtype == seglib.CountySubdivision {
 		segmetricsfile = strings.Replace(segmetricsfile, "#####", "", 1)
-	case seglib.Tract, seglib.BlockGroup:
+	} else {
 		segmetricsfile = strings.Replace(segmetricsfile, "#####", fmt.Sprintf("_%d", *buffer), 1)
-	default:
-		panic("Unkown region type")
 	}
-	regions := getSeg(segmetricsfile, regtype)
+	regions := getSeg(cfg.OutPath(segmetricsfile))
 
 	// Open a shapefile for reading
-	sf := path.Join("shapefiles", *region, shapefile)
+	sf := cfg.ShapePath(*region, shapefile)
 	shapef, err := shp.Open(sf)
 	if err != nil {
 		panic(err)
@@ -293,18 +264,14 @@ func main() {
 		for k, f := range fields {
 			attrs[f.String()] = shapef.ReadAttribute(n, k)
 		}
-		state := attrs["STATE"]
-		county := attrs["COUNTY"]
 		var id string
-		switch regtype {
-		case seglib.CountySubdivision:
-			id = state + county + attrs["COUSUB"]
-		case seglib.Tract:
-			id = state + county + attrs["TRACT"]
-		case seglib.BlockGroup:
-			id = state + county + attrs["TRACT"] + attrs["BLKGRP"]
-		default:
-			panic("Invalid region type")
+		for _, a := range shapeAttrs {
+			id += attrs[a]
+		}
+		if regtype == seglib.ZCTA {
+			// The ZCTA shapefile has no state, the regions are the parts of
+			// the ZCTAs in this state.
+			id = state.FIPS + id
 		}
 		segdata, ok := regions[id]
 		if !ok {
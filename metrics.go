@@ -4,15 +4,11 @@
 package main
 
 import (
-	"compress/gzip"
-	"encoding/gob"
 	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"math"
 	"os"
-	"path"
 	"sort"
 	"strings"
 
@@ -25,9 +21,11 @@ import (
 )
 
 var (
+	cfg seglib.Config
+
 	year int
 
-	// 99999 for 2010, 9999 for 2000
+	// 99999 for 2010 and 2020, 9999 for 1990 and 2000
 	nullCBSA string
 
 	sumlevel seglib.RegionType
@@ -45,6 +43,25 @@ var (
 
 	// Scaling parameter for exponential weights
 	escale float64
+
+	// The population universe, "total" or "vap" for the voting age
+	// population
+	universe string
+
+	// The definition of the White and Black populations, "nonhispanic",
+	// "hispanic" or "alone"
+	definition string
+
+	// The housing units attributed to the non-Black and non-White
+	// populations of each CBSA, see huCount
+	cbsaHU map[string][2]float64
+
+	// If not nil, only the regions in these states are written, identified
+	// by FIPS code
+	keepStates map[string]bool
+
+	// The files that the regions were read from, for the output header
+	inputs []seglib.InputFile
 )
 
 const (
@@ -54,57 +71,107 @@ const (
 )
 
 func getRegions() {
+	regions = readRaw(sumlevel)
+}
 
-	var fname string
-	switch sumlevel {
-	case seglib.CountySubdivision:
-		fname = fmt.Sprintf("segregation_raw_cousub_%4d.gob.gz", year)
-	case seglib.Tract:
-		fname = fmt.Sprintf("segregation_raw_tract_%4d.gob.gz", year)
-	case seglib.BlockGroup:
-		fname = fmt.Sprintf("segregation_raw_blockgroup_%4d.gob.gz", year)
-	default:
-		panic("Unkown summary level\n")
-	}
+// readRaw reads the regions collated at the given summary level.
+func readRaw(sumlevel seglib.RegionType) []*seglib.Region {
+
+	fname := cfg.OutPath(fmt.Sprintf("segregation_raw_%s_%4d.gob.gz", sumlevel, year))
 
-	fid, err := os.Open(fname)
+	fmt.Printf("Reading regions from '%s'\n", fname)
+	rl, hdr, err := seglib.ReadRegions(fname)
 	if err != nil {
 		panic(err)
 	}
-	defer fid.Close()
-	fmt.Printf("Reading regions from '%s'\n", fname)
+	if hdr.Year != year || hdr.Type != sumlevel {
+		panic(fmt.Sprintf("'%s' has %d %s regions, expected %d %s\n", fname, hdr.Year, hdr.Type, year, sumlevel))
+	}
 
-	gid, err := gzip.NewReader(fid)
+	h, err := seglib.HashFile(fname)
 	if err != nil {
 		panic(err)
 	}
-	defer gid.Close()
+	inputs = append(inputs, h)
 
-	dec := gob.NewDecoder(gid)
+	return rl
+}
 
-	for {
-		var r seglib.Region
-		err := dec.Decode(&r)
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			panic(err)
+// blockCentroids moves each tract or block group to the population
+// weighted centroid of its blocks.  Regions with no population in the
+// block file keep their internal point from the geographic header.
+func blockCentroids() {
+
+	var n int
+	switch sumlevel {
+	case seglib.Tract:
+		n = 11
+	case seglib.BlockGroup:
+		n = 12
+	default:
+		panic("Block centroids can only be used with tracts and block groups\n")
+	}
+
+	type centroid struct {
+		lon, lat, pop float64
+	}
+	cent := make(map[string]*centroid)
+	for _, b := range readRaw(seglib.Block) {
+		if b.TotalPop == 0 || len(b.Block) < n {
+			continue
+		}
+		id := b.Block[:n]
+		c, ok := cent[id]
+		if !ok {
+			c = new(centroid)
+			cent[id] = c
 		}
+		w := float64(b.TotalPop)
+		c.lon += w * b.Location[0]
+		c.lat += w * b.Location[1]
+		c.pop += w
+	}
 
-		regions = append(regions, &r)
+	for _, r := range regions {
+		id := r.Tract
+		if sumlevel == seglib.BlockGroup {
+			id = r.BlockGroup
+		}
+		if c, ok := cent[id]; ok {
+			r.Location = orb.Point{c.lon / c.pop, c.lat / c.pop}
+		}
 	}
 }
 
 func getCBSAStats() {
 
-	cbsa := make(map[string][3]int)
+	cbsa := make(map[string][4]int)
+	cbsarace := make(map[string]seglib.RacePop)
 
 	for _, r := range regions {
 		x := cbsa[r.CBSA]
 		x[0] += r.TotalPop
 		x[1] += r.BlackOnlyPop
 		x[2] += r.WhiteOnlyPop
+		x[3] += r.HispanicPop
 		cbsa[r.CBSA] = x
+
+		y := cbsarace[r.CBSA]
+		y.Add(r.RacePop)
+		cbsarace[r.CBSA] = y
+	}
+
+	cbsagroups := make(map[string]map[string]int)
+	for _, r := range regions {
+		cbsagroups[r.CBSA] = addGroups(cbsagroups[r.CBSA], r.Groups)
+	}
+
+	cbsaHU = make(map[string][2]float64)
+	for _, r := range regions {
+		x := cbsaHU[r.CBSA]
+		x[0] += huCount(r, r.TotalPop-r.BlackOnlyPop)
+		x[1] += huCount(r, r.TotalPop-r.WhiteOnlyPop)
+		cbsaHU[r.CBSA] = x
 	}
 
 	for _, r := range regions {
@@ -112,9 +179,111 @@ func getCBSAStats() {
 		r.CBSATotalPop = x[0]
 		r.CBSABlackOnlyPop = x[1]
 		r.CBSAWhiteOnlyPop = x[2]
+		r.CBSAHispanicPop = x[3]
+		r.CBSARacePop = cbsarace[r.CBSA]
+		r.CBSAGroups = cbsagroups[r.CBSA]
+	}
+}
+
+// addGroups adds the group counts in src to dst, allocating dst if it is
+// nil and there are groups to add.
+func addGroups(dst, src map[string]int) map[string]int {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]int)
+	}
+	for k, v := range src {
+		dst[k] += v
+	}
+	return dst
+}
+
+// huCount returns the number of housing units in region r attributed to
+// a group with population pop, assuming that the group has the same
+// number of housing units per person as the whole region.
+func huCount(r *seglib.Region, pop int) float64 {
+	if r.TotalPop == 0 {
+		return 0
+	}
+	return float64(r.HousingUnits) * float64(pop) / float64(r.TotalPop)
+}
+
+// applyUniverse replaces the total population counts with the voting age
+// counts if requested, so that every measure is computed for the voting
+// age population.  The VAP fields are left as they are.  The user-defined
+// groups are only counted for the total population, so they can't be used
+// with the voting age population.
+func applyUniverse() {
+
+	switch universe {
+	case "total":
+		// Nothing to do
+	case "vap":
+		for _, r := range regions {
+			if len(r.Groups) > 0 {
+				panic("-universe=vap can't be used with the groups defined by collate -groups\n")
+			}
+			r.TotalPop = r.VAPTotalPop
+			r.BlackOnlyPop = r.VAPBlackOnlyPop
+			r.WhiteOnlyPop = r.VAPWhiteOnlyPop
+			r.HispanicPop = r.VAPHispanicPop
+			r.NHBlackPop = r.VAPNHBlackPop
+			r.NHWhitePop = r.VAPNHWhitePop
+			r.RacePop = r.VAPRacePop
+		}
+	default:
+		panic(fmt.Sprintf("Unknown universe '%s'\n", universe))
 	}
 }
 
+// applyDefinition sets the White and Black populations used by the
+// segregation measures.  The raw files hold the non-Hispanic populations
+// from table P2, which are used by both the "nonhispanic" and "hispanic"
+// definitions; the "hispanic" definition also computes the Hispanic
+// isolation and dissimilarity, and uses Hispanic as a separate entropy
+// group.  The "alone" definition uses the race alone populations from
+// table P1, regardless of Hispanic origin.
+func applyDefinition() {
+
+	switch definition {
+	case "nonhispanic", "hispanic":
+		// Nothing to do
+	case "alone":
+		for _, r := range regions {
+			r.WhiteOnlyPop = r.RacePop[seglib.White]
+			r.BlackOnlyPop = r.RacePop[seglib.Black]
+		}
+	default:
+		panic(fmt.Sprintf("Unknown definition '%s'\n", definition))
+	}
+}
+
+// selectStates restricts the regions to those in the listed states and
+// their neighbors.  The neighboring states are a buffer so that the
+// neighborhoods of regions near a state line are complete, but only the
+// regions in the listed states are written.  The CBSA totals should be
+// computed before calling this.
+func selectStates(list string) {
+
+	states, err := seglib.ParseStates(list, true)
+	if err != nil {
+		panic(err)
+	}
+	keepStates = seglib.FIPSSet(states, false)
+	buffer := seglib.FIPSSet(states, true)
+
+	var sel []*seglib.Region
+	for _, r := range regions {
+		if buffer[r.StateId] {
+			sel = append(sel, r)
+		}
+	}
+	fmt.Printf("Selected %d of %d regions, including neighboring states\n", len(sel), len(regions))
+	regions = sel
+}
+
 // Find the quadrant where q lies relative to r.
 func quad(r, q orb.Point) int {
 	angle := math.Atan2(q[1]-r[1], q[0]-r[0])
@@ -153,7 +322,7 @@ func getShapes() map[string]orb.Bound {
 
 	shapes := make(map[string]orb.Bound)
 
-	files, err := ioutil.ReadDir(path.Join("shapefiles", "cousub"))
+	files, err := ioutil.ReadDir(cfg.ShapePath("cousub"))
 	if err != nil {
 		panic(err)
 	}
@@ -164,7 +333,7 @@ func getShapes() map[string]orb.Bound {
 			continue
 		}
 
-		sf := path.Join("shapefiles", "cousub", file.Name())
+		sf := cfg.ShapePath("cousub", file.Name())
 		shapef, err := shp.Open(sf)
 		if err != nil {
 			panic(err)
@@ -330,13 +499,22 @@ func main() {
 
 	flag.IntVar(&year, "year", 0, "Census year")
 	var sl string
-	flag.StringVar(&sl, "sumlevel", "", "Summary level ('blockgroup', 'cousub', or 'tract')")
+	flag.StringVar(&sl, "sumlevel", "", "Summary level, one of "+strings.Join(seglib.RegionTypeNames(), ", "))
 	flag.IntVar(&targetpop, "targetpop", 0, "Target population")
 	flag.Float64Var(&maxradius, "maxradius", 30, "Maximum radius in miles")
 	flag.Float64Var(&escale, "escale", 2.0, "Exponential scaling parameter")
 	var outname string
 	flag.StringVar(&outname, "outfile", "", "File name for output")
+	flag.StringVar(&universe, "universe", "total", "Population universe: 'total' or 'vap' for the voting age population")
+	flag.StringVar(&definition, "definition", "nonhispanic",
+		"Population groups: 'nonhispanic' for non-Hispanic White and Black, 'hispanic' to also compute the Hispanic measures, or 'alone' for White and Black alone")
+	statesf := flag.String("states", "", "Comma-separated postal or FIPS codes of the states to process (default all)")
+	centroids := flag.Bool("centroids", false, "Locate tracts and block groups at the population weighted centroids of their blocks")
+	cfg.RegisterFlags(flag.CommandLine)
 	flag.Parse()
+	if err := cfg.Resolve(); err != nil {
+		panic(err)
+	}
 
 	if outname == "" {
 		msg := "Output file name must be provided\n"
@@ -344,22 +522,16 @@ func main() {
 		os.Exit(1)
 	}
 
-	switch sl {
-	case "cousub":
-		sumlevel = seglib.CountySubdivision
-	case "tract":
-		sumlevel = seglib.Tract
-	case "blockgroup":
-		sumlevel = seglib.BlockGroup
-	default:
-		msg := fmt.Sprintf("Unknown sumlevel '%s'\n", sl)
-		panic(msg)
+	var err error
+	sumlevel, err = seglib.ParseRegionType(sl)
+	if err != nil {
+		panic(err)
 	}
 
 	switch year {
-	case 2010:
+	case 2010, 2020:
 		nullCBSA = "99999"
-	case 2000:
+	case 1990, 2000:
 		nullCBSA = "9999"
 	default:
 		panic("Invalid year")
@@ -371,8 +543,17 @@ func main() {
 	}
 
 	getRegions()
+	if *centroids {
+		blockCentroids()
+	}
+	applyUniverse()
+	applyDefinition()
 	getCBSAStats()
 
+	if *statesf != "" {
+		selectStates(*statesf)
+	}
+
 	if sumlevel == seglib.CountySubdivision {
 		regboxes = getShapes()
 	}
@@ -384,33 +565,56 @@ func main() {
 		qt.Add(r)
 	}
 
-	fid, err := os.Create(outname)
+	outname = cfg.OutPath(outname)
+	hdr := seglib.Header{
+		Program:    "metrics",
+		Year:       year,
+		Type:       sumlevel,
+		TargetPop:  targetpop,
+		Escale:     escale,
+		MaxRadius:  maxradius,
+		Universe:   universe,
+		Definition: definition,
+		Centroids:  *centroids,
+		Inputs:     inputs,
+	}
+	out, err := seglib.CreateRegions(outname, hdr)
 	if err != nil {
 		panic(err)
 	}
-	defer fid.Close()
 	fmt.Printf("Writing regions to '%s'\n", outname)
 
-	gid := gzip.NewWriter(fid)
-	defer gid.Close()
-	enc := gob.NewEncoder(gid)
-
 	var ns neighborhoodSearch
 	ns.init(qt, 1000)
 
 	for _, r := range regions {
 
+		if keepStates != nil && !keepStates[r.StateId] {
+			continue
+		}
+
 		// The outer container based on cardinal directions
+		var pcbsaHU [2]float64
 		if sumlevel == seglib.CountySubdivision {
 			cdn := findNeighbors(qt, r)
 			r.PCBSATotalPop = r.TotalPop
 			r.PCBSABlackOnlyPop = r.BlackOnlyPop
 			r.PCBSAWhiteOnlyPop = r.WhiteOnlyPop
+			r.PCBSARacePop = r.RacePop
+			r.PCBSAHispanicPop = r.HispanicPop
+			r.PCBSAGroups = addGroups(nil, r.Groups)
+			pcbsaHU[0] = huCount(r, r.TotalPop-r.BlackOnlyPop)
+			pcbsaHU[1] = huCount(r, r.TotalPop-r.WhiteOnlyPop)
 			for _, z := range cdn {
 				if z != nil {
 					r.PCBSATotalPop += z.TotalPop
 					r.PCBSABlackOnlyPop += z.BlackOnlyPop
 					r.PCBSAWhiteOnlyPop += z.WhiteOnlyPop
+					r.PCBSARacePop.Add(z.RacePop)
+					r.PCBSAHispanicPop += z.HispanicPop
+					r.PCBSAGroups = addGroups(r.PCBSAGroups, z.Groups)
+					pcbsaHU[0] += huCount(z, z.TotalPop-z.BlackOnlyPop)
+					pcbsaHU[1] += huCount(z, z.TotalPop-z.WhiteOnlyPop)
 				}
 			}
 		}
@@ -435,7 +639,9 @@ func main() {
 		r.PBlack = 0
 		r.PWhite = 0
 		r.RegionPop = 0
-		var dt, nTotal, nBlack, nWhite float64
+		var dt, nTotal, nBlack, nWhite, nHisp, nHispIso float64
+		var nHU, nVacant, nHUNonBlack, nHUNonWhite float64
+		nGroups := make(map[string]float64)
 		r.Neighbors = 0
 		for j, z := range nbds {
 
@@ -457,19 +663,41 @@ func main() {
 			bopt := 1 + float64(z.BlackOnlyPop)
 			wopt := 1 + float64(z.WhiteOnlyPop)
 
+			// Hispanics are only a separate group under the "hispanic"
+			// definition.
+			var hopt float64
+			if definition == "hispanic" {
+				hopt = float64(z.HispanicPop)
+			}
+
 			nTotal += w * popt
 			nBlack += w * bopt
 			nWhite += w * wopt
+			nHisp += w * hopt
+			nHispIso += w * (1 + hopt)
+
+			for k, v := range z.Groups {
+				nGroups[k] += w * (1 + float64(v))
+			}
+
+			nHU += w * float64(z.HousingUnits)
+			nVacant += w * float64(z.VacantUnits)
+			nHUNonBlack += w * huCount(z, z.TotalPop-z.BlackOnlyPop)
+			nHUNonWhite += w * huCount(z, z.TotalPop-z.WhiteOnlyPop)
 
 			pBlack := bopt / popt
 			pWhite := wopt / popt
+			pHisp := hopt / popt
 
 			// Local entropy is only based on one region
 			if j == 0 {
-				pOther := 1 - pBlack - pWhite
+				pOther := 1 - pBlack - pWhite - pHisp
 				r.LocalEntropy = -pBlack * math.Log(pBlack)
 				r.LocalEntropy -= pWhite * math.Log(pWhite)
 				r.LocalEntropy -= pOther * math.Log(pOther)
+				if pHisp > 0 {
+					r.LocalEntropy -= pHisp * math.Log(pHisp)
+				}
 			}
 
 			r.PBlack += w * popt * pBlack
@@ -519,6 +747,71 @@ func main() {
 				qr2 = float64(nTotal-nWhite) / float64(r.CBSATotalPop-r.CBSAWhiteOnlyPop)
 			}
 			r.WODissimilarity = math.Abs(clip01(qr1) - clip01(qr2))
+
+			r.HispanicIsolation = math.NaN()
+			r.HODissimilarity = math.NaN()
+			if definition == "hispanic" {
+				if r.CBSA == nullCBSA {
+					numer = float64(r.TotalPop - r.HispanicPop)
+					denom = float64(r.PCBSATotalPop - r.PCBSAHispanicPop)
+					qr1 = float64(r.HispanicPop) / float64(r.PCBSAHispanicPop)
+				} else {
+					numer = float64(nTotal - nHispIso)
+					denom = float64(r.CBSATotalPop - r.CBSAHispanicPop)
+					qr1 = float64(nHispIso) / float64(r.CBSAHispanicPop)
+				}
+				qr2 = numer / denom
+				r.HispanicIsolation = clip01(1 - numer/denom)
+				r.HODissimilarity = math.Abs(clip01(qr1) - clip01(qr2))
+			}
+		}
+
+		// The measures for the user-defined groups, computed in the same
+		// way as for the Black population
+		r.GroupIsolation = nil
+		r.GroupDissimilarity = nil
+		if len(r.Groups) > 0 {
+			r.GroupIsolation = make(map[string]float64)
+			r.GroupDissimilarity = make(map[string]float64)
+		}
+		for k, v := range r.Groups {
+			var numer, denom, qr1, qr2 float64
+			if r.CBSA == nullCBSA {
+				g := float64(r.PCBSAGroups[k])
+				numer = float64(r.TotalPop - v)
+				denom = float64(r.PCBSATotalPop) - g
+				qr1 = float64(v) / g
+				qr2 = numer / denom
+			} else {
+				g := float64(r.CBSAGroups[k])
+				numer = nTotal - nGroups[k]
+				denom = float64(r.CBSATotalPop) - g
+				qr1 = nGroups[k] / g
+				qr2 = numer / denom
+			}
+			r.GroupIsolation[k] = clip01(1 - numer/denom)
+			r.GroupDissimilarity[k] = math.Abs(clip01(qr1) - clip01(qr2))
+		}
+
+		// Housing unit measures, which are missing if there is no
+		// housing data
+		r.VacancyRate = math.NaN()
+		r.BlackIsolationHU = math.NaN()
+		r.WhiteIsolationHU = math.NaN()
+		if nHU > 0 {
+			r.VacancyRate = nVacant / nHU
+
+			var numer, denom [2]float64
+			if r.CBSA == nullCBSA {
+				numer[0] = huCount(r, r.TotalPop-r.BlackOnlyPop)
+				numer[1] = huCount(r, r.TotalPop-r.WhiteOnlyPop)
+				denom = pcbsaHU
+			} else {
+				numer = [2]float64{nHUNonBlack, nHUNonWhite}
+				denom = cbsaHU[r.CBSA]
+			}
+			r.BlackIsolationHU = clip01(1 - numer[0]/denom[0])
+			r.WhiteIsolationHU = clip01(1 - numer[1]/denom[1])
 		}
 
 		// Regional entropy
@@ -531,7 +824,14 @@ func main() {
 			if pWhite < 1e-4 {
 				pWhite = 1e-4
 			}
-			pOther := 1 - pBlack - pWhite
+			var pHisp float64
+			if definition == "hispanic" {
+				pHisp = nHisp / nTotal
+				if pHisp < 1e-4 {
+					pHisp = 1e-4
+				}
+			}
+			pOther := 1 - pBlack - pWhite - pHisp
 			if pOther < 1e-4 {
 				pOther = 1e-4
 			}
@@ -539,12 +839,19 @@ func main() {
 				r.RegionalEntropy = -pBlack * math.Log(pBlack)
 				r.RegionalEntropy -= pWhite * math.Log(pWhite)
 				r.RegionalEntropy -= pOther * math.Log(pOther)
+				if pHisp > 0 {
+					r.RegionalEntropy -= pHisp * math.Log(pHisp)
+				}
 			}
 		}
 
-		err = enc.Encode(r)
+		err = out.Write(r)
 		if err != nil {
 			panic(err)
 		}
 	}
+
+	if err := out.Close(); err != nil {
+		panic(err)
+	}
 }
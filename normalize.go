@@ -1,13 +1,9 @@
 package main
 
 import (
-	"compress/gzip"
-	"encoding/gob"
+	"flag"
 	"fmt"
-	"io"
 	"math"
-	"os"
-	"regexp"
 	"sort"
 	"strings"
 	"sync"
@@ -20,7 +16,7 @@ import (
 var (
 	sumlevel seglib.RegionType
 
-	// 99999 for 2010, 9999 for 2000
+	// 99999 for 2010 and 2020, 9999 for 1990 and 2000
 	nullCBSA string
 )
 
@@ -272,67 +268,68 @@ func processRural(regs []*seglib.Region,
 	wg.Wait()
 }
 
-func load(inName string) []*seglib.Region {
+// load reads the regions to normalize, setting the summary level and
+// CBSA null code from the file header.  The returned header describes the
+// output.
+func load(inName string) ([]*seglib.Region, seglib.Header) {
 
-	lname := strings.ToLower(inName)
-	switch {
-	case strings.Contains(lname, "tract"):
-		sumlevel = seglib.Tract
-	case strings.Contains(lname, "blockgroup"):
-		sumlevel = seglib.BlockGroup
-	case strings.Contains(lname, "cousub"):
-		sumlevel = seglib.CountySubdivision
-	default:
-		panic("Unknown summary level")
-	}
-
-	inf, err := os.Open(inName)
+	regs, hdr, err := seglib.ReadRegions(inName)
 	if err != nil {
 		panic(err)
 	}
-	defer inf.Close()
+	fmt.Printf("Found %s\n", hdr)
 
-	ing, err := gzip.NewReader(inf)
-	if err != nil {
-		panic(err)
-	}
+	sumlevel = hdr.Type
 
-	dec := gob.NewDecoder(ing)
+	switch hdr.Year {
+	case 2010, 2020:
+		nullCBSA = "99999"
+	case 1990, 2000:
+		nullCBSA = "9999"
+	default:
+		panic(fmt.Sprintf("unknown year: %d\n", hdr.Year))
+	}
 
-	var regs []*seglib.Region
-	for {
-		var r seglib.Region
-		err := dec.Decode(&r)
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			panic(err)
-		}
-		regs = append(regs, &r)
+	h, err := seglib.HashFile(inName)
+	if err != nil {
+		panic(err)
 	}
+	out := *hdr
+	out.Program = "normalize"
+	out.Inputs = []seglib.InputFile{h}
 
-	return regs
+	return regs, out
 }
 
 func main() {
 
-	inName := os.Args[1]
+	statesf := flag.String("states", "", "Comma-separated postal or FIPS codes of the states to write (default all), the normalization uses all states")
+	var cfg seglib.Config
+	cfg.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	if err := cfg.Resolve(); err != nil {
+		panic(err)
+	}
+
+	inName := cfg.OutPath(flag.Arg(0))
 	if !strings.HasSuffix(inName, ".gob.gz") {
 		panic("Invalid input file\n")
 	}
 	fmt.Printf("Reading unnormalized results from from '%s'\n", inName)
 
-	fp := regexp.MustCompile(`[_\.]`).Split(inName, -1)
-	switch fp[2] {
-	case "2010":
-		nullCBSA = "99999"
-	case "2000":
-		nullCBSA = "9999"
-	default:
-		panic(fmt.Sprintf("unknown year: %s\n", fp[2]))
+	// The normalization is fitted to all of the regions in the file, so
+	// that the results for a state don't depend on which states are
+	// written.
+	var keep map[string]bool
+	if *statesf != "" {
+		states, err := seglib.ParseStates(*statesf, true)
+		if err != nil {
+			panic(err)
+		}
+		keep = seglib.FIPSSet(states, false)
 	}
 
-	regs := load(inName)
+	regs, hdr := load(inName)
 
 	for _, t := range []struct {
 		sel1 func(*seglib.Region) float64
@@ -372,21 +369,22 @@ func main() {
 	}
 	fmt.Printf("Writing normalized results to to '%s'\n", outName)
 
-	outf, err := os.Create(outName)
+	out, err := seglib.CreateRegions(outName, hdr)
 	if err != nil {
 		panic(err)
 	}
-	defer outf.Close()
-
-	outg := gzip.NewWriter(outf)
-	defer outg.Close()
-
-	enc := gob.NewEncoder(outg)
 
 	for _, r := range regs {
-		err := enc.Encode(r)
+		if keep != nil && !keep[r.StateId] {
+			continue
+		}
+		err := out.Write(r)
 		if err != nil {
 			panic(err)
 		}
 	}
+
+	if err := out.Close(); err != nil {
+		panic(err)
+	}
 }
@@ -0,0 +1,126 @@
+package seglib
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+const (
+	// The defaults used when a directory is not set by a flag, the
+	// environment, or the configuration file
+	DefaultDataDir  = "/dsi/stage/stage/cscar-census"
+	DefaultOutDir   = "."
+	DefaultShapeDir = "shapefiles"
+
+	// The configuration file that is read from the working directory if
+	// no other file is named
+	DefaultConfigFile = "segmetrics.json"
+)
+
+// Config holds the directories shared by all the segmetrics commands.
+// Each directory is taken from the first of these that sets it: a
+// command line flag, an environment variable, the configuration file,
+// or the default.
+type Config struct {
+
+	// The root of the raw census data tree (SEGMETRICS_DATADIR)
+	DataDir string
+
+	// Where intermediate and output files are read and written
+	// (SEGMETRICS_OUTDIR)
+	OutDir string
+
+	// The directory containing the shapefiles (SEGMETRICS_SHAPEDIR)
+	ShapeDir string
+
+	// A JSON file with any of the fields above (SEGMETRICS_CONFIG)
+	ConfigFile string `json:"-"`
+}
+
+// RegisterFlags adds the -datadir, -outdir, -shapedir and -config flags
+// to fs.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.DataDir, "datadir", "", "Root of the raw census data")
+	fs.StringVar(&c.OutDir, "outdir", "", "Directory for intermediate and output files")
+	fs.StringVar(&c.ShapeDir, "shapedir", "", "Directory containing the shapefiles")
+	fs.StringVar(&c.ConfigFile, "config", "", "Configuration file")
+}
+
+// Resolve fills in the directories that were not set by flags, using the
+// environment, then the configuration file, then the defaults.  It should
+// be called after the flags are parsed.
+func (c *Config) Resolve() error {
+
+	for _, x := range []struct {
+		v   *string
+		env string
+	}{
+		{&c.DataDir, "SEGMETRICS_DATADIR"},
+		{&c.OutDir, "SEGMETRICS_OUTDIR"},
+		{&c.ShapeDir, "SEGMETRICS_SHAPEDIR"},
+		{&c.ConfigFile, "SEGMETRICS_CONFIG"},
+	} {
+		if *x.v == "" {
+			*x.v = os.Getenv(x.env)
+		}
+	}
+
+	// The configuration file is optional unless it was named explicitly
+	fname := c.ConfigFile
+	if fname == "" {
+		fname = DefaultConfigFile
+	}
+	var file Config
+	fid, err := os.Open(fname)
+	switch {
+	case err == nil:
+		defer fid.Close()
+		if err := json.NewDecoder(fid).Decode(&file); err != nil {
+			return fmt.Errorf("%s: %v", fname, err)
+		}
+	case c.ConfigFile != "" || !os.IsNotExist(err):
+		return err
+	}
+
+	for _, x := range []struct {
+		v, file *string
+		def     string
+	}{
+		{&c.DataDir, &file.DataDir, DefaultDataDir},
+		{&c.OutDir, &file.OutDir, DefaultOutDir},
+		{&c.ShapeDir, &file.ShapeDir, DefaultShapeDir},
+	} {
+		if *x.v == "" {
+			*x.v = *x.file
+		}
+		if *x.v == "" {
+			*x.v = x.def
+		}
+	}
+
+	return nil
+}
+
+// RedistrictingDir returns the directory holding the PL 94-171 files for
+// the given census year.
+func (c *Config) RedistrictingDir(year int) string {
+	return path.Join(c.DataDir, "redistricting-data", fmt.Sprintf("%4d", year))
+}
+
+// OutPath returns the location of an intermediate or output file.
+// Relative names are placed in the output directory.
+func (c *Config) OutPath(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(c.OutDir, name)
+}
+
+// ShapePath returns the location of a file under the shapefile directory.
+func (c *Config) ShapePath(elem ...string) string {
+	return filepath.Join(append([]string{c.ShapeDir}, elem...)...)
+}
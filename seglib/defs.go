@@ -10,33 +10,89 @@ const (
 	Tract RegionType = iota
 	BlockGroup
 	CountySubdivision
+	Block
+	County
+	Place
+	SchoolDistrict
+	ZCTA
+	CongressionalDistrict
+	StateUpperDistrict
+	StateLowerDistrict
 )
 
 type Region struct {
 
 	// These values depend only on this region
-	State        string
-	StateId      string
-	County       string
-	Cousub       string
-	Tract        string
-	BlockGroup   string
-	Name         string
-	CBSA         string
-	Type         RegionType
-	Location     orb.Point
+	State      string
+	StateId    string
+	County     string
+	Cousub     string
+	Tract      string
+	BlockGroup string
+	Block      string
+	Name       string
+
+	// The identifier of the region at its summary level, e.g. the 11
+	// digit tract code for tracts.  This is the only identifier for
+	// places, districts and ZCTAs.
+	GeoId string
+
+	CBSA     string
+	Type     RegionType
+	Location orb.Point
+
+	// The populations used for the segregation measures.  These are the
+	// non-Hispanic White and Black populations from table P2, unless
+	// metrics.go is run with a different definition.
 	TotalPop     int
 	BlackOnlyPop int
 	WhiteOnlyPop int
 
+	// Hispanic origin counts from table P2: Hispanic or Latino (P0020002),
+	// and not Hispanic, White alone (P0020005) and Black alone (P0020006).
+	// These are not changed by the metrics -definition flag.
+	HispanicPop int
+	NHWhitePop  int
+	NHBlackPop  int
+
+	// The population of each race from table P1
+	RacePop RacePop
+
+	// The voting age (18 and over) counts from tables P3 and P4,
+	// corresponding to the fields above
+	VAPTotalPop     int
+	VAPBlackOnlyPop int
+	VAPWhiteOnlyPop int
+	VAPHispanicPop  int
+	VAPNHWhitePop   int
+	VAPNHBlackPop   int
+	VAPRacePop      RacePop
+
+	// Housing units from table H1, which is not in the 1990 and 2000
+	// files
+	HousingUnits  int
+	OccupiedUnits int
+	VacantUnits   int
+
+	// The counts of the groups defined with collate -groups, by name
+	Groups GroupCounts
+
 	CBSATotalPop     int
 	CBSABlackOnlyPop int
 	CBSAWhiteOnlyPop int
+	CBSARacePop      RacePop
+	CBSAHispanicPop  int
 
 	// Pseudo-CBSA
 	PCBSATotalPop     int
 	PCBSABlackOnlyPop int
 	PCBSAWhiteOnlyPop int
+	PCBSARacePop      RacePop
+	PCBSAHispanicPop  int
+
+	// The group counts for the CBSA and pseudo-CBSA
+	CBSAGroups  GroupCounts
+	PCBSAGroups GroupCounts
 
 	// These values depend on the region's neighbors
 	RegionPop    int
@@ -52,12 +108,30 @@ type Region struct {
 	BlackIsolationResid float64
 	WhiteIsolationResid float64
 
+	// Isolation measures with each region's groups weighted by its housing
+	// units rather than its population
+	BlackIsolationHU float64
+	WhiteIsolationHU float64
+
+	// The smoothed proportion of housing units that are vacant
+	VacancyRate float64
+
 	// Dissimilarity measures
 	BODissimilarity      float64
 	WODissimilarity      float64
 	BODissimilarityResid float64
 	WODissimilarityResid float64
 
+	// The isolation and dissimilarity of the Hispanic population, which
+	// are only computed with metrics -definition=hispanic and are NaN
+	// otherwise
+	HispanicIsolation float64
+	HODissimilarity   float64
+
+	// The isolation and dissimilarity of each user-defined group
+	GroupIsolation     map[string]float64
+	GroupDissimilarity map[string]float64
+
 	LocalEntropy    float64
 	RegionalEntropy float64
 
@@ -0,0 +1,295 @@
+package seglib
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Column is a cell of one of the PL 94-171 tables, located by the data
+// segment that contains it and its position in the segment's records.
+type Column struct {
+	Name    string
+	Segment int
+	Index   int
+}
+
+// The layout of the tables in the 2000, 2010 and 2020 data segments
+var plTables = map[string]struct {
+	segment, start, cells int
+}{
+	"P1": {1, 5, 71},
+	"P2": {1, 76, 73},
+	"P3": {2, 5, 71},
+	"P4": {2, 76, 73},
+	"H1": {2, 149, 3},
+}
+
+var (
+	// Column names as in the 2010 files (P0010006), or the 2020 files
+	// (P1_006N, with the trailing N optional)
+	colName2010 = regexp.MustCompile(`^([PH])(\d{3})(\d{4})$`)
+	colName2020 = regexp.MustCompile(`^([PH])(\d+)_(\d+)N?$`)
+
+	groupName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+)
+
+// ParseColumn locates a PL column given its name, e.g. "P0010006" or
+// "P1_006N".
+func ParseColumn(name string) (Column, error) {
+
+	u := strings.ToUpper(name)
+	m := colName2010.FindStringSubmatch(u)
+	if m == nil {
+		m = colName2020.FindStringSubmatch(u)
+	}
+	if m == nil {
+		return Column{}, fmt.Errorf("invalid column name '%s'", name)
+	}
+
+	tab, _ := strconv.Atoi(m[2])
+	cell, _ := strconv.Atoi(m[3])
+	t, ok := plTables[fmt.Sprintf("%s%d", m[1], tab)]
+	if !ok {
+		return Column{}, fmt.Errorf("column '%s' is not in a PL table", name)
+	}
+	if cell < 1 || cell > t.cells {
+		return Column{}, fmt.Errorf("column '%s' is out of range", name)
+	}
+
+	return Column{Name: name, Segment: t.segment, Index: t.start + cell - 1}, nil
+}
+
+// ColumnName returns the name, in the 2010 style, of the PL column at a
+// position in the records of a data segment.  Positions outside of the
+// tables are described by their number.
+func ColumnName(segment, index int) string {
+	for tab, t := range plTables {
+		if t.segment == segment && index >= t.start && index < t.start+t.cells {
+			n, _ := strconv.Atoi(tab[1:])
+			return fmt.Sprintf("%s%03d%04d", tab[:1], n, index-t.start+1)
+		}
+	}
+	return fmt.Sprintf("column %d", index)
+}
+
+// Group is a user-defined population group, counted as a sum and
+// difference of PL columns.
+type Group struct {
+	Name  string
+	Plus  []Column
+	Minus []Column
+}
+
+// Count returns the size of the group for one logical record, given the
+// records from data segments 1 and 2.
+func (g *Group) Count(seg1, seg2 []string) (int, error) {
+
+	get := func(c Column) (int, error) {
+		rec := seg1
+		if c.Segment == 2 {
+			rec = seg2
+		}
+		if c.Index >= len(rec) {
+			return 0, fmt.Errorf("group %s: column %s is not in this file", g.Name, c.Name)
+		}
+		x, err := strconv.Atoi(rec[c.Index])
+		if err != nil {
+			return 0, &FieldError{Field: c.Name, Value: rec[c.Index], Err: err}
+		}
+		return x, nil
+	}
+
+	var n int
+	for _, c := range g.Plus {
+		x, err := get(c)
+		if err != nil {
+			return 0, err
+		}
+		n += x
+	}
+	for _, c := range g.Minus {
+		x, err := get(c)
+		if err != nil {
+			return 0, err
+		}
+		n -= x
+	}
+
+	return n, nil
+}
+
+// ParseGroups reads group definitions, one per line, of the form
+//
+//	Asian = P0010006 + P0010013 - P0020008
+//
+// Blank lines and text following '#' are ignored.  Group names must be
+// valid identifiers since they are used in the column names of the
+// output files.
+func ParseGroups(r io.Reader) ([]Group, error) {
+
+	var groups []Group
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for ln := 1; scanner.Scan(); ln++ {
+
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		lhs, rhs, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: missing '='", ln)
+		}
+
+		g := Group{Name: strings.TrimSpace(lhs)}
+		if !groupName.MatchString(g.Name) {
+			return nil, fmt.Errorf("line %d: invalid group name '%s'", ln, g.Name)
+		}
+		if seen[g.Name] {
+			return nil, fmt.Errorf("line %d: group '%s' is defined twice", ln, g.Name)
+		}
+		seen[g.Name] = true
+
+		// Put spaces around the operators so that the terms can be
+		// split on white space.
+		rhs = strings.NewReplacer("+", " + ", "-", " - ").Replace(rhs)
+		sign := 1
+		expectCol := true
+		for _, tok := range strings.Fields(rhs) {
+			switch {
+			case tok == "+" || tok == "-":
+				if expectCol {
+					return nil, fmt.Errorf("line %d: unexpected '%s'", ln, tok)
+				}
+				sign = 1
+				if tok == "-" {
+					sign = -1
+				}
+				expectCol = true
+			case expectCol:
+				c, err := ParseColumn(tok)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", ln, err)
+				}
+				if sign > 0 {
+					g.Plus = append(g.Plus, c)
+				} else {
+					g.Minus = append(g.Minus, c)
+				}
+				expectCol = false
+			default:
+				return nil, fmt.Errorf("line %d: expected an operator before '%s'", ln, tok)
+			}
+		}
+		if expectCol {
+			return nil, fmt.Errorf("line %d: incomplete expression", ln)
+		}
+
+		groups = append(groups, g)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// ReadGroups reads the group definitions in the named file, see
+// ParseGroups.
+func ReadGroups(fname string) ([]Group, error) {
+
+	fid, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer fid.Close()
+
+	groups, err := ParseGroups(fid)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fname, err)
+	}
+
+	return groups, nil
+}
+
+// GroupNames returns the names of the groups in a set of group counts, in
+// sorted order.
+func GroupNames(counts map[string]int) []string {
+
+	var names []string
+	for k := range counts {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// GroupCounts holds the counts of the user-defined groups, by name.  Gob
+// encodes maps in a random order, so the counts are encoded in the order
+// of the names to make the output files reproducible.
+type GroupCounts map[string]int
+
+// GobEncode encodes the counts as the number of groups, followed by the
+// length of each name, the name and the count.
+func (gc GroupCounts) GobEncode() ([]byte, error) {
+
+	buf := binary.AppendUvarint(nil, uint64(len(gc)))
+	for _, k := range GroupNames(gc) {
+		buf = binary.AppendUvarint(buf, uint64(len(k)))
+		buf = append(buf, k...)
+		buf = binary.AppendVarint(buf, int64(gc[k]))
+	}
+
+	return buf, nil
+}
+
+// GobDecode decodes counts written by GobEncode.
+func (gc *GroupCounts) GobDecode(buf []byte) error {
+
+	errShort := fmt.Errorf("group counts are truncated")
+
+	n, k := binary.Uvarint(buf)
+	if k <= 0 {
+		return errShort
+	}
+	buf = buf[k:]
+
+	// Each group takes at least two bytes, the name length and the count,
+	// so a larger n can only come from a corrupt file.
+	if n > uint64(len(buf))/2 {
+		return errShort
+	}
+
+	m := make(GroupCounts, n)
+	for i := uint64(0); i < n; i++ {
+		ln, k := binary.Uvarint(buf)
+		if k <= 0 || uint64(len(buf)-k) < ln {
+			return errShort
+		}
+		name := string(buf[k : k+int(ln)])
+		buf = buf[k+int(ln):]
+
+		x, k := binary.Varint(buf)
+		if k <= 0 {
+			return errShort
+		}
+		buf = buf[k:]
+		m[name] = int(x)
+	}
+
+	*gc = m
+	return nil
+}
@@ -0,0 +1,162 @@
+package seglib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseColumn(t *testing.T) {
+
+	for _, tc := range []struct {
+		name string
+		want Column
+	}{
+		{"P0010001", Column{"P0010001", 1, 5}},
+		{"P0010003", Column{"P0010003", 1, 7}},
+		{"P0020005", Column{"P0020005", 1, 80}},
+		{"p0030001", Column{"p0030001", 2, 5}},
+		{"P4_006N", Column{"P4_006N", 2, 81}},
+		{"H1_003", Column{"H1_003", 2, 151}},
+	} {
+		c, err := ParseColumn(tc.name)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		} else if c != tc.want {
+			t.Errorf("%s: got %+v, expected %+v", tc.name, c, tc.want)
+		}
+		// The names are given in the 2010 style
+		if n := ColumnName(c.Segment, c.Index); colName2010.MatchString(strings.ToUpper(tc.name)) && n != strings.ToUpper(tc.name) {
+			t.Errorf("%s: ColumnName gives %s", tc.name, n)
+		}
+	}
+
+	for _, name := range []string{"P0010000", "P0010072", "P5_001N", "H0010004", "P001", "Q0010001"} {
+		if _, err := ParseColumn(name); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestParseGroups(t *testing.T) {
+
+	in := `
+# Asian alone or with White, less the non-Hispanic Asian alone
+Asian = P0010006 + P0010013-P0020008   # trailing comment
+
+Total=P1_001N
+`
+	groups, err := ParseGroups(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	col := func(name string) Column {
+		c, err := ParseColumn(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	want := []Group{
+		{Name: "Asian", Plus: []Column{col("P0010006"), col("P0010013")}, Minus: []Column{col("P0020008")}},
+		{Name: "Total", Plus: []Column{col("P1_001N")}},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("got %+v, expected %+v", groups, want)
+	}
+
+	// Count the groups from a segment 1 record
+	rec := make([]string, 149)
+	for j := range rec {
+		rec[j] = "0"
+	}
+	rec[5], rec[10], rec[17], rec[83] = "100", "7", "2", "1"
+	for j, w := range []int{8, 100} {
+		n, err := groups[j].Count(rec, nil)
+		if err != nil {
+			t.Error(err)
+		} else if n != w {
+			t.Errorf("%s: got %d, expected %d", groups[j].Name, n, w)
+		}
+	}
+
+	for _, bad := range []string{
+		"Asian P0010006",
+		"1Asian = P0010006",
+		"Asian = P0010006\nAsian = P0010005",
+		"Asian = P0010006 +",
+		"Asian = + P0010006",
+		"Asian = P0010006 P0010005",
+		"Asian = P0090001",
+		"Asian =",
+	} {
+		if _, err := ParseGroups(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+func TestGroupCountsGob(t *testing.T) {
+
+	for _, gc := range []GroupCounts{
+		nil,
+		{},
+		{"Black": 4},
+		{"Asian": 1958, "Black": 4, "Other": -1},
+	} {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(Region{Groups: gc}); err != nil {
+			t.Fatal(err)
+		}
+		var r Region
+		if err := gob.NewDecoder(&buf).Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		if len(gc) == 0 && len(r.Groups) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(gc, r.Groups) {
+			t.Errorf("got %v, expected %v", r.Groups, gc)
+		}
+	}
+}
+
+// The counts must be encoded in the same way every time, so that the
+// output files are reproducible.
+func TestGroupCountsOrder(t *testing.T) {
+
+	gc := GroupCounts{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5}
+	want, err := gc.GobEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		got, _ := gc.GobEncode()
+		if !bytes.Equal(got, want) {
+			t.Fatalf("encoding changed from %v to %v", want, got)
+		}
+	}
+}
+
+func TestGroupCountsCorrupt(t *testing.T) {
+
+	good, err := GroupCounts{"Black": 4, "White": 10}.GobEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A huge number of groups must be an error, not an allocation
+	huge := binary.AppendUvarint(nil, 1<<62)
+	huge = append(huge, good[1:]...)
+
+	for _, buf := range [][]byte{nil, good[:len(good)-1], good[:3], huge} {
+		var gc GroupCounts
+		if err := gc.GobDecode(buf); err == nil {
+			t.Errorf("no error decoding %v", buf)
+		}
+	}
+}
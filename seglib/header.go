@@ -0,0 +1,118 @@
+package seglib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// HeaderVersion is the version of the layout of the region files.  It
+// should be incremented when fields of Region or Header are changed in a
+// way that older files can't be read.
+const HeaderVersion = 1
+
+// InputFile identifies a file that was read to produce a region file.
+type InputFile struct {
+	Name   string
+	SHA256 string
+}
+
+// Header is the first record of every region file, it describes how the
+// regions were produced.
+type Header struct {
+
+	// HeaderVersion when the file was written, this is set by
+	// RegionWriter
+	Version int
+
+	// The command that wrote the file, e.g. "collate"
+	Program string
+
+	Year int
+	Type RegionType
+
+	// The neighborhood parameters used by metrics, these are zero in the
+	// raw files
+	TargetPop int
+	Escale    float64
+	MaxRadius float64
+
+	// The population universe ("total" or "vap") and the definition of
+	// the populations ("nonhispanic", "hispanic" or "alone") used by
+	// metrics, and whether the regions were located at the centroids of
+	// their blocks.  These are empty in the raw files.
+	Universe   string
+	Definition string
+	Centroids  bool
+
+	// The files that were read, by base name
+	Inputs []InputFile
+
+	// When the file was written, taken from SOURCE_DATE_EPOCH.  This is
+	// zero if SOURCE_DATE_EPOCH is not set, so that running a command
+	// again writes exactly the same file.
+	Created time.Time
+}
+
+func (h *Header) String() string {
+	s := fmt.Sprintf("%s %d %s, version %d", h.Program, h.Year, h.Type, h.Version)
+	if !h.Created.IsZero() {
+		s += ", created " + h.Created.Format(time.RFC3339)
+	}
+	return s
+}
+
+// check returns an error if the header can't be used with this version
+// of the code.
+func (h *Header) check() error {
+
+	switch {
+	case h.Version == 0:
+		return fmt.Errorf("the file has no header, it may have been written by an older version")
+	case h.Version != HeaderVersion:
+		return fmt.Errorf("the file has version %d, expected %d", h.Version, HeaderVersion)
+	case !h.Type.Valid():
+		return fmt.Errorf("the header has unknown region type %d", h.Type)
+	}
+
+	return nil
+}
+
+// creationTime returns the time given in seconds by SOURCE_DATE_EPOCH, or
+// the zero time if it is not set.
+func creationTime() (time.Time, error) {
+
+	s := os.Getenv("SOURCE_DATE_EPOCH")
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	x, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH '%s': %v", s, err)
+	}
+
+	return time.Unix(x, 0).UTC(), nil
+}
+
+// HashFile returns the base name and SHA-256 hash of a file.
+func HashFile(fname string) (InputFile, error) {
+
+	fid, err := os.Open(fname)
+	if err != nil {
+		return InputFile{}, err
+	}
+	defer fid.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, fid); err != nil {
+		return InputFile{}, err
+	}
+
+	return InputFile{Name: filepath.Base(fname), SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
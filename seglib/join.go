@@ -0,0 +1,88 @@
+package seglib
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The position of LOGRECNO in the data segment records
+const segLogrecno = 4
+
+// SegmentRecord is a record from a PL data segment, with its line number
+// in the file.
+type SegmentRecord struct {
+	Line   int
+	Fields []string
+}
+
+// Segment holds records from a PL data segment, indexed by logical record
+// number.
+type Segment struct {
+	File    string
+	Records map[string]SegmentRecord
+
+	// The logical record numbers in the file that are not in the
+	// geographic header
+	Extra []string
+
+	// The records that could not be read, as *RecordError
+	Errors []error
+}
+
+// ReadSegment reads a data segment from r, keeping the records whose
+// logical record numbers are in keep.  The numbers of records that are not
+// in known, which should hold every logical record of the geographic
+// header, are collected in Extra.  The file name is only used in errors.
+//
+// A record with the wrong number of fields or a repeated logical record
+// number is added to Errors and reading continues.  A repeated logical
+// record is left out of Records, since it is not known which of the
+// copies is right.  The returned error is only set if the file can't be
+// read.
+func ReadSegment(r *csv.Reader, fname string, keep, known map[string]bool) (*Segment, error) {
+
+	seg := &Segment{
+		File:    fname,
+		Records: make(map[string]SegmentRecord),
+	}
+
+	recErr := func(line int, format string, args ...interface{}) {
+		seg.Errors = append(seg.Errors, &RecordError{File: fname, Line: line, Err: fmt.Errorf(format, args...)})
+	}
+
+	seen := make(map[string]bool)
+	for line := 1; ; line++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if pe, ok := err.(*csv.ParseError); ok {
+			recErr(line, "%v", pe.Err)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", fname, line, err)
+		}
+
+		if len(rec) <= segLogrecno {
+			recErr(line, "record has only %d fields", len(rec))
+			continue
+		}
+		lr := strings.TrimSpace(rec[segLogrecno])
+		if seen[lr] {
+			recErr(line, "logical record %s is repeated", lr)
+			delete(seg.Records, lr)
+			continue
+		}
+		seen[lr] = true
+
+		if !known[lr] {
+			seg.Extra = append(seg.Extra, lr)
+		}
+		if keep[lr] {
+			seg.Records[lr] = SegmentRecord{Line: line, Fields: rec}
+		}
+	}
+
+	return seg, nil
+}
@@ -0,0 +1,97 @@
+package seglib
+
+import (
+	"encoding/csv"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// set returns a set holding the given logical record numbers.
+func set(lr ...string) map[string]bool {
+	m := make(map[string]bool)
+	for _, x := range lr {
+		m[x] = true
+	}
+	return m
+}
+
+func TestReadSegment(t *testing.T) {
+
+	in := `PLST,MI,000,01,0000001,10
+PLST,MI,000,01,0000003,30
+PLST,MI,000,01,0000004,40
+PLST,MI,000,01, 0000002 ,20
+`
+	// Record 5 is missing from the segment, and record 4 is not in the
+	// geographic header
+	keep := set("0000001", "0000002", "0000005")
+	known := set("0000001", "0000002", "0000003", "0000005")
+
+	seg, err := ReadSegment(csv.NewReader(strings.NewReader(in)), "mi000012010.pl", keep, known)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]SegmentRecord{
+		"0000001": {Line: 1, Fields: []string{"PLST", "MI", "000", "01", "0000001", "10"}},
+		"0000002": {Line: 4, Fields: []string{"PLST", "MI", "000", "01", " 0000002 ", "20"}},
+	}
+	if !reflect.DeepEqual(seg.Records, want) {
+		t.Errorf("got records %v, expected %v", seg.Records, want)
+	}
+	if !reflect.DeepEqual(seg.Extra, []string{"0000004"}) {
+		t.Errorf("got extra records %v, expected [0000004]", seg.Extra)
+	}
+}
+
+func TestReadSegmentErrors(t *testing.T) {
+
+	in := `PLST,MI,000,01,0000001,10
+PLST,MI,000,01,0000002,20
+PLST,MI,000,01,0000001,10
+PLST,MI,000,01,0000004
+PLST,MI,0"00,01,0000005,50
+PLST,MI,000,01,0000003,30
+`
+	keep := set("0000001", "0000002", "0000003")
+	seg, err := ReadSegment(csv.NewReader(strings.NewReader(in)), "seg.pl", keep, keep)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The repeated record is dropped, and reading continues after the
+	// records that can't be read.
+	if len(seg.Records) != 2 || seg.Records["0000002"].Line != 2 || seg.Records["0000003"].Line != 6 {
+		t.Errorf("got records %v", seg.Records)
+	}
+
+	want := []struct {
+		line int
+		msg  string
+	}{
+		{3, "logical record 0000001 is repeated"},
+		{4, "wrong number of fields"},
+		{5, "bare \""},
+	}
+	if len(seg.Errors) != len(want) {
+		t.Fatalf("got errors %v, expected %d", seg.Errors, len(want))
+	}
+	for j, w := range want {
+		re, ok := seg.Errors[j].(*RecordError)
+		if !ok || re.File != "seg.pl" || re.Line != w.line || !strings.Contains(re.Err.Error(), w.msg) {
+			t.Errorf("got %v, expected seg.pl:%d: %s", seg.Errors[j], w.line, w.msg)
+		}
+	}
+
+	// A record that is too short to have a logical record number
+	r := csv.NewReader(strings.NewReader("PLST,MI,000,01,0000001,10\nPLST,MI\n"))
+	r.FieldsPerRecord = -1
+	seg, err = ReadSegment(r, "seg.pl", keep, keep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seg.Errors) != 1 || !strings.Contains(seg.Errors[0].Error(), "seg.pl:2: record has only 2 fields") {
+		t.Errorf("got errors %v for a short record", seg.Errors)
+	}
+}
@@ -0,0 +1,284 @@
+package seglib
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// GeoRecord holds the fields that are used from a record of a PL geographic
+// header file.
+type GeoRecord struct {
+	Sumlevel   string
+	StateId    string
+	County     string
+	Cousub     string
+	Tract      string
+	BlockGroup string
+	Block      string
+	Logrecno   string
+
+	Place                 string
+	SchoolDistrict        string
+	ZCTA                  string
+	CongressionalDistrict string
+	StateUpperDistrict    string
+	StateLowerDistrict    string
+
+	Name string
+	CBSA string
+	Lat  float64
+	Lon  float64
+}
+
+// FieldType is the type of a field in a geographic header record.
+type FieldType uint8
+
+const (
+	StringField FieldType = iota
+	FloatField
+)
+
+// Field describes where one field of a GeoRecord is found in a geographic
+// header record.
+type Field struct {
+
+	// The name of the GeoRecord field that is filled
+	Name string
+
+	// For fixed-width layouts, the 0-based offset and width of the field
+	// in bytes.  For delimited layouts, Start is the 0-based position of
+	// the field and Length is not used.
+	Start  int
+	Length int
+
+	Type FieldType
+
+	// The value to use if the field is blank
+	Default string
+
+	// Float fields are divided by this if it is not zero, for files with
+	// an implied decimal point
+	Scale float64
+}
+
+// Layout describes the fields in the geographic header records of one
+// census year.
+type Layout struct {
+	Year int
+
+	// If not empty, the records are split on this rather than being
+	// fixed-width
+	Delim string
+
+	Fields []Field
+}
+
+// FieldError describes a field that could not be parsed.
+type FieldError struct {
+	Field string
+	Value string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("field %s: %v", e.Field, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// RecordError describes a record of a census file that could not be
+// parsed.
+type RecordError struct {
+	State string
+	File  string
+	Line  int
+	Err   error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("%s: %s:%d: %v", e.State, e.File, e.Line, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	// The geographic header layouts for each census year
+	GeoLayouts = map[int]*Layout{
+		1990: {
+			// TODO: check the 1990 offsets against the codebook
+			Year: 1990,
+			Fields: []Field{
+				{Name: "Sumlevel", Start: 8, Length: 3},
+				{Name: "Logrecno", Start: 18, Length: 7},
+				{Name: "StateId", Start: 29, Length: 2},
+				{Name: "County", Start: 31, Length: 3},
+				{Name: "Cousub", Start: 36, Length: 5},
+				{Name: "Tract", Start: 55, Length: 6},
+				{Name: "BlockGroup", Start: 61, Length: 1},
+				{Name: "Block", Start: 62, Length: 4},
+				{Name: "CBSA", Start: 106, Length: 4, Default: "9999"}, // MSA/CMSA
+				{Name: "Name", Start: 200, Length: 66},
+				{Name: "Lat", Start: 280, Length: 9, Type: FloatField, Scale: 1e6},
+				{Name: "Lon", Start: 289, Length: 10, Type: FloatField, Scale: 1e6},
+			},
+		},
+		2000: {
+			Year: 2000,
+			Fields: []Field{
+				{Name: "Sumlevel", Start: 8, Length: 3},
+				{Name: "Logrecno", Start: 18, Length: 7},
+				{Name: "StateId", Start: 29, Length: 2},
+				{Name: "County", Start: 31, Length: 3},
+				{Name: "Cousub", Start: 36, Length: 5},
+				{Name: "Tract", Start: 55, Length: 6},
+				{Name: "BlockGroup", Start: 61, Length: 1},
+				{Name: "Block", Start: 62, Length: 4},
+				{Name: "Place", Start: 45, Length: 5},
+				{Name: "CBSA", Start: 106, Length: 4}, // CMSA
+				{Name: "Name", Start: 200, Length: 90},
+				{Name: "Lat", Start: 310, Length: 9, Type: FloatField, Scale: 1e6},
+				{Name: "Lon", Start: 319, Length: 10, Type: FloatField, Scale: 1e6},
+			},
+		},
+		2010: {
+			Year: 2010,
+			Fields: []Field{
+				{Name: "Sumlevel", Start: 8, Length: 3},
+				{Name: "Logrecno", Start: 18, Length: 7},
+				{Name: "StateId", Start: 27, Length: 2},
+				{Name: "County", Start: 29, Length: 3},
+				{Name: "Cousub", Start: 36, Length: 5},
+				{Name: "Tract", Start: 54, Length: 6},
+				{Name: "BlockGroup", Start: 60, Length: 1},
+				{Name: "Block", Start: 61, Length: 4},
+				{Name: "Place", Start: 45, Length: 5},
+				{Name: "CBSA", Start: 112, Length: 5},
+				{Name: "CongressionalDistrict", Start: 153, Length: 2},
+				{Name: "StateUpperDistrict", Start: 155, Length: 3},
+				{Name: "StateLowerDistrict", Start: 158, Length: 3},
+				{Name: "ZCTA", Start: 171, Length: 5},
+				{Name: "SchoolDistrict", Start: 193, Length: 5},
+				{Name: "Name", Start: 226, Length: 90},
+				{Name: "Lat", Start: 336, Length: 11, Type: FloatField},
+				{Name: "Lon", Start: 347, Length: 12, Type: FloatField},
+			},
+		},
+		2020: {
+			Year:  2020,
+			Delim: "|",
+			Fields: []Field{
+				{Name: "Sumlevel", Start: 2},
+				{Name: "Logrecno", Start: 7},
+				{Name: "StateId", Start: 12},
+				{Name: "County", Start: 14},
+				{Name: "Cousub", Start: 17},
+				{Name: "Tract", Start: 32},
+				{Name: "BlockGroup", Start: 33},
+				{Name: "Block", Start: 34},
+				{Name: "Place", Start: 29},
+				// The districts of the 116th Congress and the 2018
+				// state legislatures
+				{Name: "CongressionalDistrict", Start: 62},
+				{Name: "StateUpperDistrict", Start: 67},
+				{Name: "StateLowerDistrict", Start: 72},
+				{Name: "ZCTA", Start: 79},
+				{Name: "SchoolDistrict", Start: 82},
+				// Areas outside of any CBSA have a blank code, use the
+				// same null code as in 2010.
+				{Name: "CBSA", Start: 49, Default: "99999"},
+				{Name: "Name", Start: 87},
+				{Name: "Lat", Start: 92, Type: FloatField},
+				{Name: "Lon", Start: 93, Type: FloatField},
+			},
+		},
+	}
+)
+
+// GeoLayout returns the geographic header layout for a census year.
+func GeoLayout(year int) (*Layout, error) {
+	l, ok := GeoLayouts[year]
+	if !ok {
+		return nil, fmt.Errorf("no geographic layout for %d", year)
+	}
+	return l, nil
+}
+
+// Check verifies that every field of the layout names a GeoRecord field of
+// the right type.
+func (l *Layout) Check() error {
+
+	t := reflect.TypeOf(GeoRecord{})
+	for _, f := range l.Fields {
+		sf, ok := t.FieldByName(f.Name)
+		if !ok {
+			return fmt.Errorf("%d layout: unknown field %s", l.Year, f.Name)
+		}
+		want := reflect.String
+		if f.Type == FloatField {
+			want = reflect.Float64
+		}
+		if sf.Type.Kind() != want {
+			return fmt.Errorf("%d layout: field %s has the wrong type", l.Year, f.Name)
+		}
+		if l.Delim == "" && f.Length <= 0 {
+			return fmt.Errorf("%d layout: field %s has no width", l.Year, f.Name)
+		}
+	}
+
+	return nil
+}
+
+// Parse fills gr from a geographic header record.  Fields that are not in
+// the layout are left unchanged.  If a field can't be read, the error is
+// a *FieldError.
+func (l *Layout) Parse(rec string, gr *GeoRecord) error {
+
+	var parts []string
+	if l.Delim != "" {
+		parts = strings.Split(rec, l.Delim)
+	}
+
+	v := reflect.ValueOf(gr).Elem()
+	for _, f := range l.Fields {
+
+		var x string
+		if l.Delim != "" {
+			if f.Start >= len(parts) {
+				return &FieldError{Field: f.Name, Err: fmt.Errorf("record has only %d fields", len(parts))}
+			}
+			x = parts[f.Start]
+		} else {
+			if f.Start+f.Length > len(rec) {
+				return &FieldError{Field: f.Name, Err: fmt.Errorf("record has only %d bytes", len(rec))}
+			}
+			x = rec[f.Start : f.Start+f.Length]
+		}
+
+		x = strings.TrimSpace(x)
+		if x == "" {
+			x = f.Default
+		}
+
+		switch f.Type {
+		case StringField:
+			v.FieldByName(f.Name).SetString(x)
+		case FloatField:
+			y, err := strconv.ParseFloat(x, 64)
+			if err != nil {
+				return &FieldError{Field: f.Name, Value: x, Err: err}
+			}
+			if f.Scale != 0 {
+				y /= f.Scale
+			}
+			v.FieldByName(f.Name).SetFloat(y)
+		}
+	}
+
+	return nil
+}
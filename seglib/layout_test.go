@@ -0,0 +1,146 @@
+package seglib
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLayoutCheck(t *testing.T) {
+
+	for year, l := range GeoLayouts {
+		if l.Year != year {
+			t.Errorf("%d layout has year %d", year, l.Year)
+		}
+		if err := l.Check(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	bad := []*Layout{
+		{Year: 1, Fields: []Field{{Name: "Nothing", Start: 0, Length: 1}}},
+		{Year: 2, Fields: []Field{{Name: "Lat", Start: 0, Length: 1}}},
+		{Year: 3, Fields: []Field{{Name: "Name", Start: 0, Length: 1, Type: FloatField}}},
+		{Year: 4, Fields: []Field{{Name: "Name", Start: 0}}},
+	}
+	for _, l := range bad {
+		if err := l.Check(); err == nil {
+			t.Errorf("no error for layout %d", l.Year)
+		}
+	}
+}
+
+// A value at a position of a geographic header record, as given in the
+// technical documentation of the PL files.  Fixed-width positions are
+// 1-based, delimited positions are the 1-based field numbers.
+type docField struct {
+	pos   int
+	value string
+}
+
+// record builds a geographic header record with the given fields.
+func record(delim string, fields []docField) string {
+
+	if delim != "" {
+		parts := make([]string, 100)
+		for _, f := range fields {
+			parts[f.pos-1] = f.value
+		}
+		return strings.Join(parts, delim)
+	}
+
+	rec := []byte(strings.Repeat(" ", 400))
+	for _, f := range fields {
+		copy(rec[f.pos-1:], f.value)
+	}
+	return string(rec)
+}
+
+func TestLayoutParse(t *testing.T) {
+
+	for _, tc := range []struct {
+		year   int
+		fields []docField
+		want   GeoRecord
+	}{
+		{
+			year: 2000,
+			fields: []docField{
+				{9, "140"}, {19, "0000123"}, {30, "26"}, {32, "161"}, {37, "03000"},
+				{46, "03000"}, {56, "400100"}, {62, "1"}, {63, "1001"}, {107, "2160"},
+				{201, "Census Tract 4001"}, {311, "+42280000"}, {320, "-083740000"},
+			},
+			want: GeoRecord{Sumlevel: "140", Logrecno: "0000123", StateId: "26", County: "161",
+				Cousub: "03000", Place: "03000", Tract: "400100", BlockGroup: "1", Block: "1001",
+				CBSA: "2160", Name: "Census Tract 4001", Lat: 42.28, Lon: -83.74},
+		},
+		{
+			year: 2010,
+			fields: []docField{
+				{9, "871"}, {19, "0000123"}, {28, "26"}, {30, "161"}, {37, "03000"},
+				{46, "03000"}, {55, "400100"}, {61, "1"}, {62, "1001"}, {113, "11460"},
+				{154, "12"}, {156, "018"}, {159, "053"}, {172, "48104"}, {194, "02340"},
+				{227, "ZCTA5 48104"}, {337, "+42.2800000"}, {348, "-083.7400000"},
+			},
+			want: GeoRecord{Sumlevel: "871", Logrecno: "0000123", StateId: "26", County: "161",
+				Cousub: "03000", Place: "03000", Tract: "400100", BlockGroup: "1", Block: "1001",
+				CBSA: "11460", CongressionalDistrict: "12", StateUpperDistrict: "018",
+				StateLowerDistrict: "053", ZCTA: "48104", SchoolDistrict: "02340",
+				Name: "ZCTA5 48104", Lat: 42.28, Lon: -83.74},
+		},
+		{
+			year: 2020,
+			fields: []docField{
+				{3, "860"}, {8, "0000123"}, {13, "26"}, {15, "161"}, {18, "03000"},
+				{30, "03000"}, {33, "400100"}, {34, "1"}, {35, "1001"},
+				{63, "12"}, {68, "018"}, {73, "053"}, {80, "48104"}, {83, "02340"},
+				{88, "ZCTA5 48104"}, {93, "+42.2800000"}, {94, "-083.7400000"},
+			},
+			want: GeoRecord{Sumlevel: "860", Logrecno: "0000123", StateId: "26", County: "161",
+				Cousub: "03000", Place: "03000", Tract: "400100", BlockGroup: "1", Block: "1001",
+				CBSA: "99999", CongressionalDistrict: "12", StateUpperDistrict: "018",
+				StateLowerDistrict: "053", ZCTA: "48104", SchoolDistrict: "02340",
+				Name: "ZCTA5 48104", Lat: 42.28, Lon: -83.74},
+		},
+	} {
+		l, err := GeoLayout(tc.year)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var gr GeoRecord
+		if err := l.Parse(record(l.Delim, tc.fields), &gr); err != nil {
+			t.Errorf("%d: %v", tc.year, err)
+			continue
+		}
+		if gr != tc.want {
+			t.Errorf("%d: got %+v, expected %+v", tc.year, gr, tc.want)
+		}
+	}
+}
+
+func TestLayoutParseError(t *testing.T) {
+
+	l, err := GeoLayout(2010)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fe *FieldError
+	var gr GeoRecord
+
+	// A truncated record
+	if err := l.Parse(strings.Repeat(" ", 100), &gr); !errors.As(err, &fe) {
+		t.Errorf("got %v, expected a *FieldError", err)
+	}
+
+	// A latitude that is not a number
+	rec := record("", []docField{{337, "north"}, {348, "-083.7400000"}})
+	if err := l.Parse(rec, &gr); !errors.As(err, &fe) || fe.Field != "Lat" {
+		t.Errorf("got %v, expected an error for Lat", err)
+	}
+
+	if _, err := GeoLayout(1980); err == nil {
+		t.Errorf("no error for an unknown year")
+	}
+}
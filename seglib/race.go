@@ -0,0 +1,53 @@
+package seglib
+
+// Race indexes the single race and multiracial categories of PL 94-171
+// table P1.
+type Race uint8
+
+const (
+	White Race = iota
+	Black
+	AmericanIndian
+	Asian
+	PacificIslander
+	OtherRace
+	TwoOrMore
+	NumRaces
+)
+
+// RaceNames are short names for the race categories, in Race order.
+var RaceNames = [NumRaces]string{
+	"White",
+	"Black",
+	"AmericanIndian",
+	"Asian",
+	"PacificIslander",
+	"OtherRace",
+	"TwoOrMore",
+}
+
+func (r Race) String() string {
+	return RaceNames[r]
+}
+
+// RacePop holds the population of each race category.  The categories
+// other than TwoOrMore count people of one race only, regardless of
+// Hispanic origin.
+type RacePop [NumRaces]int
+
+// Add adds the counts in q to p.
+func (p *RacePop) Add(q RacePop) {
+	for j := range p {
+		p[j] += q[j]
+	}
+}
+
+// Total returns the sum of the counts over all categories, which is the
+// total population.
+func (p RacePop) Total() int {
+	var n int
+	for _, x := range p {
+		n += x
+	}
+	return n
+}
@@ -0,0 +1,272 @@
+package seglib
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/paulmach/orb"
+)
+
+// RegionFilter returns true for the regions that should be kept.
+type RegionFilter func(*Region) bool
+
+// StateFilter keeps the regions in the given states, identified by FIPS
+// code as returned by FIPSSet.
+func StateFilter(fips map[string]bool) RegionFilter {
+	return func(r *Region) bool {
+		return fips[r.StateId]
+	}
+}
+
+// CBSAFilter keeps the regions in any of the given CBSAs.
+func CBSAFilter(cbsas ...string) RegionFilter {
+	keep := make(map[string]bool)
+	for _, c := range cbsas {
+		keep[c] = true
+	}
+	return func(r *Region) bool {
+		return keep[r.CBSA]
+	}
+}
+
+// BoundFilter keeps the regions whose location is in b.
+func BoundFilter(b orb.Bound) RegionFilter {
+	return func(r *Region) bool {
+		return b.Contains(r.Location)
+	}
+}
+
+// RegionReader reads regions from a gzip compressed gob file, such as the
+// files written by collate, metrics and normalize.  The header of the file
+// is read when the reader is created, then the reader is used like a
+// bufio.Scanner:
+//
+//	rr, err := seglib.OpenRegions(fname)
+//	...
+//	defer rr.Close()
+//	for rr.Next() {
+//		r := rr.Region()
+//		...
+//	}
+//	if err := rr.Err(); err != nil {
+//		...
+//	}
+type RegionReader struct {
+	fid io.Closer
+	gid *gzip.Reader
+	dec *gob.Decoder
+
+	header Header
+
+	filters []RegionFilter
+
+	region *Region
+	err    error
+}
+
+// OpenRegions opens a file of regions for reading.
+func OpenRegions(fname string) (*RegionReader, error) {
+
+	fid, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	rr, err := NewRegionReader(fid)
+	if err != nil {
+		fid.Close()
+		return nil, fmt.Errorf("%s: %v", fname, err)
+	}
+	rr.fid = fid
+
+	return rr, nil
+}
+
+// NewRegionReader reads regions from a gzip compressed gob stream,
+// starting with the header.
+func NewRegionReader(r io.Reader) (*RegionReader, error) {
+
+	gid, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rr := &RegionReader{gid: gid, dec: gob.NewDecoder(gid)}
+	if err := rr.dec.Decode(&rr.header); err != nil {
+		gid.Close()
+		return nil, fmt.Errorf("reading header: %v", err)
+	}
+	if err := rr.header.check(); err != nil {
+		gid.Close()
+		return nil, err
+	}
+
+	return rr, nil
+}
+
+// Header returns the header of the file.
+func (rr *RegionReader) Header() *Header {
+	return &rr.header
+}
+
+// Filter adds filters to the reader, only the regions that pass all of
+// the filters are returned by Next.
+func (rr *RegionReader) Filter(filters ...RegionFilter) *RegionReader {
+	rr.filters = append(rr.filters, filters...)
+	return rr
+}
+
+// Next reads the next region that passes the filters, returning false at
+// the end of the file or if there is an error.
+func (rr *RegionReader) Next() bool {
+
+	if rr.err != nil {
+		return false
+	}
+
+	for {
+		// Decode into a new region, since gob leaves the fields that are
+		// zero in the file unchanged.
+		r := new(Region)
+		if err := rr.dec.Decode(r); err != nil {
+			if err != io.EOF {
+				rr.err = err
+			}
+			rr.region = nil
+			return false
+		}
+
+		if rr.keep(r) {
+			rr.region = r
+			return true
+		}
+	}
+}
+
+func (rr *RegionReader) keep(r *Region) bool {
+	for _, f := range rr.filters {
+		if !f(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Region returns the region read by the last call to Next.  Each call to
+// Next allocates a new region, so the result can be retained.
+func (rr *RegionReader) Region() *Region {
+	return rr.region
+}
+
+// Err returns the first error that occurred while reading, other than
+// io.EOF.
+func (rr *RegionReader) Err() error {
+	return rr.err
+}
+
+// ReadAll returns the remaining regions that pass the filters.
+func (rr *RegionReader) ReadAll() ([]*Region, error) {
+
+	var rl []*Region
+	for rr.Next() {
+		rl = append(rl, rr.Region())
+	}
+
+	return rl, rr.Err()
+}
+
+// Close closes the reader, and the file if it was opened by OpenRegions.
+func (rr *RegionReader) Close() error {
+
+	err := rr.gid.Close()
+	if rr.fid != nil {
+		if e := rr.fid.Close(); err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// ReadRegions reads the header of a file and the regions that pass the
+// filters.
+func ReadRegions(fname string, filters ...RegionFilter) ([]*Region, *Header, error) {
+
+	rr, err := OpenRegions(fname)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rr.Close()
+
+	rl, err := rr.Filter(filters...).ReadAll()
+	return rl, rr.Header(), err
+}
+
+// RegionWriter writes a header and then regions to a gzip compressed gob
+// file.  Close must be called to flush the output.
+type RegionWriter struct {
+	fid io.Closer
+	gid *gzip.Writer
+	enc *gob.Encoder
+}
+
+// CreateRegions creates a file of regions, see NewRegionWriter.
+func CreateRegions(fname string, hdr Header) (*RegionWriter, error) {
+
+	fid, err := os.Create(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	rw, err := NewRegionWriter(fid, hdr)
+	if err != nil {
+		fid.Close()
+		return nil, err
+	}
+	rw.fid = fid
+
+	return rw, nil
+}
+
+// NewRegionWriter writes regions to w as a gzip compressed gob stream.
+// The header is written first, with the version set and the creation
+// time taken from SOURCE_DATE_EPOCH, as described for Header.Created.
+func NewRegionWriter(w io.Writer, hdr Header) (*RegionWriter, error) {
+
+	var err error
+	hdr.Version = HeaderVersion
+	hdr.Created, err = creationTime()
+	if err != nil {
+		return nil, err
+	}
+
+	gid := gzip.NewWriter(w)
+	rw := &RegionWriter{gid: gid, enc: gob.NewEncoder(gid)}
+	if err := rw.enc.Encode(&hdr); err != nil {
+		return nil, err
+	}
+
+	return rw, nil
+}
+
+// Write writes one region.
+func (rw *RegionWriter) Write(r *Region) error {
+	return rw.enc.Encode(r)
+}
+
+// Close flushes the output, and closes the file if it was created by
+// CreateRegions.
+func (rw *RegionWriter) Close() error {
+
+	err := rw.gid.Close()
+	if rw.fid != nil {
+		if e := rw.fid.Close(); err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,127 @@
+package seglib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRegionHeader(t *testing.T) {
+
+	t.Setenv("SOURCE_DATE_EPOCH", "1600000000")
+
+	hdr := Header{
+		Program:    "metrics",
+		Year:       2010,
+		Type:       Tract,
+		TargetPop:  10000,
+		Escale:     2,
+		MaxRadius:  50,
+		Universe:   "vap",
+		Definition: "hispanic",
+		Centroids:  true,
+		Inputs:     []InputFile{{Name: "segregation_raw_tract_2010.gob.gz", SHA256: "00ff"}},
+	}
+	regions := []*Region{
+		{StateId: "26", GeoId: "26161400100", Type: Tract, TotalPop: 100},
+		{StateId: "39", GeoId: "39049000110", Type: Tract, TotalPop: 200},
+		{StateId: "26", GeoId: "26161400200", Type: Tract, Groups: GroupCounts{"Asian": 3}},
+	}
+
+	var buf bytes.Buffer
+	rw, err := NewRegionWriter(&buf, hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range regions {
+		if err := rw.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rr, err := NewRegionReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rr.Close()
+
+	want := hdr
+	want.Version = HeaderVersion
+	want.Created = time.Unix(1600000000, 0).UTC()
+	if got := *rr.Header(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got header %+v, expected %+v", got, want)
+	}
+
+	rl, err := rr.Filter(StateFilter(map[string]bool{"26": true})).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rl) != 2 || rl[0].GeoId != regions[0].GeoId || rl[1].GeoId != regions[2].GeoId {
+		t.Fatalf("got %d regions, expected the Michigan regions", len(rl))
+	}
+
+	// The fields that are not in a region must not be carried over from
+	// the previous region.
+	if rl[1].TotalPop != 0 || rl[1].Groups["Asian"] != 3 {
+		t.Errorf("got %+v, expected %+v", rl[1], regions[2])
+	}
+}
+
+func TestRegionReproducible(t *testing.T) {
+
+	// Without SOURCE_DATE_EPOCH there is no creation time, so the same
+	// regions give the same file.
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+
+	write := func() []byte {
+		var buf bytes.Buffer
+		rw, err := NewRegionWriter(&buf, Header{Program: "collate", Year: 2010, Type: Tract})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := &Region{StateId: "26", GeoId: "26161400100", Type: Tract, Groups: GroupCounts{"Asian": 3, "Other": 4}}
+		if err := rw.Write(r); err != nil {
+			t.Fatal(err)
+		}
+		if err := rw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	first := write()
+	time.Sleep(time.Millisecond)
+	if !bytes.Equal(first, write()) {
+		t.Errorf("the files are not the same")
+	}
+
+	rr, err := NewRegionReader(bytes.NewReader(first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rr.Close()
+	if !rr.Header().Created.IsZero() {
+		t.Errorf("got creation time %v, expected none", rr.Header().Created)
+	}
+}
+
+func TestRegionNoHeader(t *testing.T) {
+
+	// A file written before the header was added starts with a region
+	var buf bytes.Buffer
+	gid := gzip.NewWriter(&buf)
+	if err := gob.NewEncoder(gid).Encode(&Region{GeoId: "26161400100"}); err != nil {
+		t.Fatal(err)
+	}
+	gid.Close()
+
+	if _, err := NewRegionReader(&buf); err == nil {
+		t.Errorf("no error for a file without a header")
+	}
+}
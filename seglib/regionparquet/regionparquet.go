@@ -0,0 +1,222 @@
+// Package regionparquet writes regions to Parquet files, with one column
+// for each field of seglib.Region.
+//
+// It is kept out of seglib so that the other commands do not depend on
+// the Parquet libraries.
+package regionparquet
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/kshedden/segregation/seglib"
+	"github.com/paulmach/orb"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// The number of goroutines used by the Parquet writer to encode pages
+const parallel = 4
+
+// The Parquet types of the fields.  Strings are used for the identifiers
+// so that FIPS and CBSA codes keep their leading zeros.  Floats are
+// optional so that the measures that are NaN can be written as nulls,
+// which Spark and DuckDB handle better than NaN.
+const (
+	stringType = "type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"
+	intType    = "type=INT64"
+	floatType  = "type=DOUBLE, repetitiontype=OPTIONAL"
+)
+
+// column is one column of the output, value returns its value for a
+// region.
+type column struct {
+	name  string
+	ptype string
+	value func(r reflect.Value) interface{}
+}
+
+func (c column) metadata() string {
+	return fmt.Sprintf("name=%s, %s", c.name, c.ptype)
+}
+
+func floatValue(x float64) interface{} {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return nil
+	}
+	return x
+}
+
+// groupColumn returns the name of the column for group g from a field
+// holding the values of all groups, using the same names as gencsv, e.g.
+// CBSAGroups becomes CBSAGroup{g}Pop and GroupIsolation becomes
+// Group{g}Isolation.
+func groupColumn(field, g string) string {
+	if strings.HasSuffix(field, "Groups") {
+		return strings.TrimSuffix(field, "Groups") + "Group" + g + "Pop"
+	}
+	return strings.Replace(field, "Group", "Group"+g, 1)
+}
+
+var (
+	regionTypeType = reflect.TypeOf(seglib.RegionType(0))
+	pointType      = reflect.TypeOf(orb.Point{})
+	racePopType    = reflect.TypeOf(seglib.RacePop{})
+	groupCountType = reflect.TypeOf(seglib.GroupCounts{})
+	groupValueType = reflect.TypeOf(map[string]float64{})
+)
+
+// columns returns the columns for the fields of seglib.Region, in the
+// order of the fields.  The race counts and the group values are spread
+// over one column per race or group.  A field of any other type is an
+// error, so that new fields are not silently left out.
+func columns(groupNames []string) ([]column, error) {
+
+	var cols []column
+	t := reflect.TypeOf(seglib.Region{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		i := i
+
+		switch f.Type {
+		case regionTypeType:
+			cols = append(cols, column{f.Name, stringType, func(r reflect.Value) interface{} {
+				return r.Field(i).Interface().(seglib.RegionType).String()
+			}})
+			continue
+		case pointType:
+			// As in the CSV files, the location is the longitude and
+			// latitude.
+			for j, name := range []string{"Lon", "Lat"} {
+				j := j
+				cols = append(cols, column{name, floatType, func(r reflect.Value) interface{} {
+					return floatValue(r.Field(i).Index(j).Float())
+				}})
+			}
+			continue
+		case racePopType:
+			pfx := strings.TrimSuffix(f.Name, "RacePop")
+			for j, rn := range seglib.RaceNames {
+				j := j
+				cols = append(cols, column{pfx + rn + "Pop", intType, func(r reflect.Value) interface{} {
+					return r.Field(i).Index(j).Int()
+				}})
+			}
+			continue
+		case groupCountType, groupValueType:
+			isCount := f.Type == groupCountType
+			for _, g := range groupNames {
+				g := g
+				if isCount {
+					cols = append(cols, column{groupColumn(f.Name, g), intType, func(r reflect.Value) interface{} {
+						return int64(r.Field(i).Interface().(seglib.GroupCounts)[g])
+					}})
+				} else {
+					cols = append(cols, column{groupColumn(f.Name, g), floatType, func(r reflect.Value) interface{} {
+						x, ok := r.Field(i).Interface().(map[string]float64)[g]
+						if !ok {
+							return nil
+						}
+						return floatValue(x)
+					}})
+				}
+			}
+			continue
+		}
+
+		switch f.Type.Kind() {
+		case reflect.String:
+			cols = append(cols, column{f.Name, stringType, func(r reflect.Value) interface{} {
+				return r.Field(i).String()
+			}})
+		case reflect.Int:
+			cols = append(cols, column{f.Name, intType, func(r reflect.Value) interface{} {
+				return r.Field(i).Int()
+			}})
+		case reflect.Float64:
+			cols = append(cols, column{f.Name, floatType, func(r reflect.Value) interface{} {
+				return floatValue(r.Field(i).Float())
+			}})
+		default:
+			return nil, fmt.Errorf("field %s has type %s, which can't be written to Parquet", f.Name, f.Type)
+		}
+	}
+
+	return cols, nil
+}
+
+// Writer writes regions to a Parquet file, with one row per region.  The
+// column statistics are written for each row group.
+type Writer struct {
+	fw   source.ParquetFile
+	pw   *writer.CSVWriter
+	cols []column
+}
+
+// Create creates a Parquet file for regions with the given user-defined
+// groups.  The header of the region file is stored as JSON in the file's
+// key/value metadata, under "segmetrics".
+func Create(fname string, hdr *seglib.Header, groupNames []string) (*Writer, error) {
+
+	cols, err := columns(groupNames)
+	if err != nil {
+		return nil, err
+	}
+	var md []string
+	for _, c := range cols {
+		md = append(md, c.metadata())
+	}
+
+	fw, err := local.NewLocalFileWriter(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	pw, err := writer.NewCSVWriter(md, fw, parallel)
+	if err != nil {
+		fw.Close()
+		return nil, err
+	}
+
+	if hdr != nil {
+		b, err := json.Marshal(hdr)
+		if err != nil {
+			fw.Close()
+			return nil, err
+		}
+		v := string(b)
+		pw.Footer.KeyValueMetadata = append(pw.Footer.KeyValueMetadata, &parquet.KeyValue{Key: "segmetrics", Value: &v})
+	}
+
+	return &Writer{fw: fw, pw: pw, cols: cols}, nil
+}
+
+// Write writes one region.
+func (w *Writer) Write(r *seglib.Region) error {
+
+	// The writer buffers the rows, so each row needs its own slice
+	v := reflect.ValueOf(r).Elem()
+	row := make([]interface{}, len(w.cols))
+	for j, c := range w.cols {
+		row[j] = c.value(v)
+	}
+
+	return w.pw.Write(row)
+}
+
+// Close writes the remaining row groups and the file footer, and closes
+// the file.
+func (w *Writer) Close() error {
+
+	err := w.pw.WriteStop()
+	if e := w.fw.Close(); err == nil {
+		err = e
+	}
+
+	return err
+}
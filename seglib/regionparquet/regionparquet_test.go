@@ -0,0 +1,144 @@
+package regionparquet
+
+import (
+	"encoding/json"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/kshedden/segregation/seglib"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func TestColumns(t *testing.T) {
+
+	cols, err := columns([]string{"Asian"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range cols {
+		if seen[c.name] {
+			t.Errorf("column %s is repeated", c.name)
+		}
+		seen[c.name] = true
+	}
+
+	for _, name := range []string{"GeoId", "Type", "Lon", "Lat", "AsianPop", "CBSAAsianPop",
+		"GroupAsianPop", "CBSAGroupAsianPop", "GroupAsianIsolation", "GroupAsianDissimilarity"} {
+		if !seen[name] {
+			t.Errorf("no column %s", name)
+		}
+	}
+	for _, name := range []string{"Location", "RacePop", "Groups", "GroupIsolation"} {
+		if seen[name] {
+			t.Errorf("field %s is not spread over columns", name)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+
+	fname := filepath.Join(t.TempDir(), "regions.parquet")
+	hdr := &seglib.Header{Program: "normalize", Year: 2010, Type: seglib.Tract, TargetPop: 10000}
+	groupNames := []string{"Asian"}
+	regions := []*seglib.Region{
+		{StateId: "06", GeoId: "06001400100", Type: seglib.Tract, PBlack: 0.25,
+			Groups: seglib.GroupCounts{"Asian": 12}, GroupIsolation: map[string]float64{"Asian": 0.5}},
+		{StateId: "06", GeoId: "06001400200", Type: seglib.Tract, PBlack: math.NaN()},
+		{StateId: "26", GeoId: "26161400100", Type: seglib.Tract, PBlack: math.Inf(1)},
+	}
+
+	w, err := Create(fname, hdr, groupNames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range regions {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fr, err := local.NewLocalFileReader(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fr.Close()
+	pr, err := reader.NewParquetColumnReader(fr, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+
+	if n := pr.GetNumRows(); n != int64(len(regions)) {
+		t.Fatalf("got %d rows, expected %d", n, len(regions))
+	}
+
+	// The column names and their order
+	cols, err := columns(groupNames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := pr.Footer.GetSchema()[1:]
+	if len(schema) != len(cols) {
+		t.Fatalf("got %d columns, expected %d", len(schema), len(cols))
+	}
+	for j, se := range schema {
+		if se.GetName() != cols[j].name {
+			t.Errorf("column %d is %s, expected %s", j, se.GetName(), cols[j].name)
+		}
+	}
+
+	// The header
+	var md *seglib.Header
+	for _, kv := range pr.Footer.GetKeyValueMetadata() {
+		if kv.GetKey() == "segmetrics" {
+			md = new(seglib.Header)
+			if err := json.Unmarshal([]byte(kv.GetValue()), md); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if md == nil {
+		t.Fatalf("no segmetrics metadata")
+	}
+	if md.Program != hdr.Program || md.Year != hdr.Year || md.Type != hdr.Type || md.TargetPop != hdr.TargetPop {
+		t.Errorf("got header %+v, expected %+v", md, hdr)
+	}
+
+	read := func(name string) []interface{} {
+		path := common.ReformPathStr(pr.SchemaHandler.GetRootExName() + "." + name)
+		v, _, _, err := pr.ReadColumnByPath(path, int64(len(regions)))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		return v
+	}
+
+	// The identifiers keep their leading zeros
+	for j, v := range read("GeoId") {
+		if v != regions[j].GeoId {
+			t.Errorf("row %d: got GeoId %v, expected %s", j, v, regions[j].GeoId)
+		}
+	}
+
+	// NaN and Inf are written as nulls
+	pb := read("PBlack")
+	if pb[0] != 0.25 || pb[1] != nil || pb[2] != nil {
+		t.Errorf("got PBlack %v, expected [0.25 <nil> <nil>]", pb)
+	}
+
+	// A missing group measure is null, a missing group count is 0
+	if gi := read("GroupAsianIsolation"); gi[0] != 0.5 || gi[1] != nil {
+		t.Errorf("got GroupAsianIsolation %v, expected [0.5 <nil> <nil>]", gi)
+	}
+	if gp := read("GroupAsianPop"); gp[0] != int64(12) || gp[1] != int64(0) {
+		t.Errorf("got GroupAsianPop %v, expected [12 0 0]", gp)
+	}
+}
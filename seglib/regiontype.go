@@ -0,0 +1,186 @@
+package seglib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// regionTypeInfo describes how regions of one type are found in the PL
+// files and in the cartographic boundary files.
+type regionTypeInfo struct {
+
+	// The name used in command line flags and in file names
+	name string
+
+	// The summary level code in the geographic header for each year in
+	// which the regions are available
+	codes map[int]string
+
+	// The names of the cartographic boundary files for each year, with
+	// ## in place of the state FIPS code for the files that are by state,
+	// and the attributes that are concatenated to form the GEOID
+	shapefiles map[int]string
+	shapeAttrs []string
+
+	// The GEOID of a region of this type, and its length
+	geoid    func(gr *GeoRecord) string
+	geoidLen int
+}
+
+var regionTypes = map[RegionType]regionTypeInfo{
+	Tract: {
+		name:       "tract",
+		codes:      map[int]string{1990: "140", 2000: "140", 2010: "140", 2020: "140"},
+		shapefiles: map[int]string{2010: "gz_2010_##_140_00_500k.shp"},
+		shapeAttrs: []string{"STATE", "COUNTY", "TRACT"},
+		geoid:      func(gr *GeoRecord) string { return gr.StateId + gr.County + gr.Tract },
+		geoidLen:   11,
+	},
+	BlockGroup: {
+		name:       "blockgroup",
+		codes:      map[int]string{1990: "150", 2000: "740", 2010: "150", 2020: "150"},
+		shapefiles: map[int]string{2010: "gz_2010_##_150_00_500k.shp"},
+		shapeAttrs: []string{"STATE", "COUNTY", "TRACT", "BLKGRP"},
+		geoid:      func(gr *GeoRecord) string { return gr.StateId + gr.County + gr.Tract + gr.BlockGroup },
+		geoidLen:   12,
+	},
+	CountySubdivision: {
+		name:       "cousub",
+		codes:      map[int]string{1990: "060", 2000: "060", 2010: "060", 2020: "060"},
+		shapefiles: map[int]string{2010: "gz_2010_##_060_00_500k.shp"},
+		shapeAttrs: []string{"STATE", "COUNTY", "COUSUB"},
+		geoid:      func(gr *GeoRecord) string { return gr.StateId + gr.County + gr.Cousub },
+		geoidLen:   10,
+	},
+	Block: {
+		name:     "block",
+		codes:    map[int]string{1990: "100", 2000: "101", 2010: "750", 2020: "750"},
+		geoid:    func(gr *GeoRecord) string { return gr.StateId + gr.County + gr.Tract + gr.Block },
+		geoidLen: 15,
+	},
+	County: {
+		name:       "county",
+		codes:      map[int]string{1990: "050", 2000: "050", 2010: "050", 2020: "050"},
+		shapefiles: map[int]string{2010: "gz_2010_us_050_00_500k.shp"},
+		shapeAttrs: []string{"STATE", "COUNTY"},
+		geoid:      func(gr *GeoRecord) string { return gr.StateId + gr.County },
+		geoidLen:   5,
+	},
+	Place: {
+		name:       "place",
+		codes:      map[int]string{2000: "160", 2010: "160", 2020: "160"},
+		shapefiles: map[int]string{2010: "gz_2010_##_160_00_500k.shp"},
+		shapeAttrs: []string{"STATE", "PLACE"},
+		geoid:      func(gr *GeoRecord) string { return gr.StateId + gr.Place },
+		geoidLen:   7,
+	},
+	SchoolDistrict: {
+		name:       "schooldistrict",
+		codes:      map[int]string{2010: "970", 2020: "970"},
+		shapefiles: map[int]string{2010: "gz_2010_##_970_00_500k.shp"},
+		shapeAttrs: []string{"STATE", "SDUNI"},
+		geoid:      func(gr *GeoRecord) string { return gr.StateId + gr.SchoolDistrict },
+		geoidLen:   7,
+	},
+	// The PL files are by state and hold the part of each ZCTA in the
+	// state, so a ZCTA that crosses a state line is in more than one file.
+	// The state is part of the GEOID to keep the parts apart.
+	ZCTA: {
+		name:       "zcta",
+		codes:      map[int]string{2010: "871", 2020: "860"},
+		shapefiles: map[int]string{2010: "gz_2010_us_860_00_500k.shp"},
+		shapeAttrs: []string{"ZCTA5"},
+		geoid:      func(gr *GeoRecord) string { return gr.StateId + gr.ZCTA },
+		geoidLen:   7,
+	},
+	CongressionalDistrict: {
+		name:       "congdist",
+		codes:      map[int]string{2010: "500", 2020: "500"},
+		shapefiles: map[int]string{2010: "gz_2010_us_500_11_500k.shp"},
+		shapeAttrs: []string{"STATE", "CD"},
+		geoid:      func(gr *GeoRecord) string { return gr.StateId + gr.CongressionalDistrict },
+		geoidLen:   4,
+	},
+	StateUpperDistrict: {
+		name:       "sldu",
+		codes:      map[int]string{2010: "610", 2020: "610"},
+		shapefiles: map[int]string{2010: "gz_2010_##_610_u2_500k.shp"},
+		shapeAttrs: []string{"STATE", "SLDU"},
+		geoid:      func(gr *GeoRecord) string { return gr.StateId + gr.StateUpperDistrict },
+		geoidLen:   5,
+	},
+	StateLowerDistrict: {
+		name:       "sldl",
+		codes:      map[int]string{2010: "620", 2020: "620"},
+		shapefiles: map[int]string{2010: "gz_2010_##_620_l2_500k.shp"},
+		shapeAttrs: []string{"STATE", "SLDL"},
+		geoid:      func(gr *GeoRecord) string { return gr.StateId + gr.StateLowerDistrict },
+		geoidLen:   5,
+	},
+}
+
+func (t RegionType) info() regionTypeInfo {
+	ti, ok := regionTypes[t]
+	if !ok {
+		panic(fmt.Sprintf("unknown region type %d", t))
+	}
+	return ti
+}
+
+// String returns the name of the region type as used in flags and file
+// names, e.g. "tract".
+func (t RegionType) String() string {
+	return t.info().name
+}
+
+// RegionTypeNames returns the names of all the region types.
+func RegionTypeNames() []string {
+	var names []string
+	for t := RegionType(0); int(t) < len(regionTypes); t++ {
+		names = append(names, t.String())
+	}
+	return names
+}
+
+// ParseRegionType returns the region type with the given name.
+func ParseRegionType(name string) (RegionType, error) {
+	for t, ti := range regionTypes {
+		if ti.name == name {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown summary level '%s', must be one of %s", name, strings.Join(RegionTypeNames(), ", "))
+}
+
+// SumlevelCode returns the summary level code of the region type in the
+// geographic header files of a census year.
+func (t RegionType) SumlevelCode(year int) (string, error) {
+	code, ok := t.info().codes[year]
+	if !ok {
+		return "", fmt.Errorf("summary level '%s' is not available for %d", t, year)
+	}
+	return code, nil
+}
+
+// ShapeFile returns the name of the cartographic boundary file holding
+// the regions of a state in a census year, and the attributes of the file
+// that form the GEOID.  Some files, e.g. for counties, cover the whole
+// country.
+func (t RegionType) ShapeFile(year int, fips string) (string, []string, error) {
+	name, ok := t.info().shapefiles[year]
+	if !ok {
+		return "", nil, fmt.Errorf("there are no %d shapefiles for '%s'", year, t)
+	}
+	return strings.Replace(name, "##", fips, 1), t.info().shapeAttrs, nil
+}
+
+// GEOIDLen returns the length of the identifiers of this region type.
+func (t RegionType) GEOIDLen() int {
+	return t.info().geoidLen
+}
+
+// GEOID returns the identifier of a region of this type from its
+// geographic header record.
+func (t RegionType) GEOID(gr *GeoRecord) string {
+	return t.info().geoid(gr)
+}
@@ -0,0 +1,76 @@
+package seglib
+
+import "testing"
+
+// The 2010 cartographic boundary files for Michigan, as published by the
+// Census Bureau.  County, ZCTA and congressional district files are only
+// published for the whole country.
+var shapefiles2010 = map[RegionType]string{
+	Tract:                 "gz_2010_26_140_00_500k.shp",
+	BlockGroup:            "gz_2010_26_150_00_500k.shp",
+	CountySubdivision:     "gz_2010_26_060_00_500k.shp",
+	County:                "gz_2010_us_050_00_500k.shp",
+	Place:                 "gz_2010_26_160_00_500k.shp",
+	SchoolDistrict:        "gz_2010_26_970_00_500k.shp",
+	ZCTA:                  "gz_2010_us_860_00_500k.shp",
+	CongressionalDistrict: "gz_2010_us_500_11_500k.shp",
+	StateUpperDistrict:    "gz_2010_26_610_u2_500k.shp",
+	StateLowerDistrict:    "gz_2010_26_620_l2_500k.shp",
+}
+
+func TestShapeFile(t *testing.T) {
+
+	for rt := range regionTypes {
+		name, attrs, err := rt.ShapeFile(2010, "26")
+		want, ok := shapefiles2010[rt]
+		if !ok {
+			if err == nil {
+				t.Errorf("%s: got %s, expected no shapefile", rt, name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", rt, err)
+			continue
+		}
+		if name != want {
+			t.Errorf("%s: got %s, expected %s", rt, name, want)
+		}
+		if len(attrs) == 0 {
+			t.Errorf("%s: no GEOID attributes", rt)
+		}
+	}
+
+	if _, _, err := Tract.ShapeFile(2000, "26"); err == nil {
+		t.Errorf("no error for the 2000 tract shapefile")
+	}
+}
+
+func TestGEOID(t *testing.T) {
+
+	gr := GeoRecord{
+		StateId: "26",
+		County:  "161",
+		Tract:   "400100",
+		ZCTA:    "48104",
+		Place:   "03000",
+	}
+
+	for _, tc := range []struct {
+		rt   RegionType
+		want string
+	}{
+		{Tract, "26161400100"},
+		{County, "26161"},
+		{Place, "2603000"},
+		{ZCTA, "2648104"},
+	} {
+		got := tc.rt.GEOID(&gr)
+		if got != tc.want {
+			t.Errorf("%s: got %s, expected %s", tc.rt, got, tc.want)
+		}
+		if len(got) != tc.rt.GEOIDLen() {
+			t.Errorf("%s: length of %s is not %d", tc.rt, got, tc.rt.GEOIDLen())
+		}
+	}
+}
@@ -1,56 +1,379 @@
 package seglib
 
+import (
+	"fmt"
+	"strings"
+
+	"github.com/paulmach/orb"
+)
+
+// CensusRegion is one of the four census regions, numbered as in the
+// census files.
+type CensusRegion uint8
+
+const (
+	NoRegion CensusRegion = iota
+	Northeast
+	Midwest
+	South
+	West
+)
+
+// CensusDivision is one of the nine census divisions, numbered as in the
+// census files.
+type CensusDivision uint8
+
+const (
+	NoDivision CensusDivision = iota
+	NewEngland
+	MiddleAtlantic
+	EastNorthCentral
+	WestNorthCentral
+	SouthAtlantic
+	EastSouthCentral
+	WestSouthCentral
+	Mountain
+	Pacific
+)
+
+// State describes a state, or a state equivalent such as the District of
+// Columbia.
+type State struct {
+
+	// The full name, e.g. "New Hampshire"
+	Name string
+
+	// The name of the state's directory in the census data tree, e.g.
+	// "New_Hampshire"
+	Dir string
+
+	// The upper case postal code, e.g. "NH"
+	Postal string
+
+	// The two digit FIPS code, e.g. "33"
+	FIPS string
+
+	Region   CensusRegion
+	Division CensusDivision
+}
+
+// Prefix returns the lower case postal code that begins the names of the
+// state's census files.
+func (s State) Prefix() string {
+	return strings.ToLower(s.Postal)
+}
+
+func (s State) String() string {
+	return s.Name
+}
+
 var (
-	States = [][2]string{
-		{"Alabama", "al"},
-		{"Alaska", "ak"},
-		{"Arizona", "az"},
-		{"Arkansas", "ar"},
-		{"California", "ca"},
-		{"Colorado", "co"},
-		{"Connecticut", "ct"},
-		{"Delaware", "de"},
-		{"Florida", "fl"},
-		{"Georgia", "ga"},
-		{"Hawaii", "hi"},
-		{"Idaho", "id"},
-		{"Illinois", "il"},
-		{"Indiana", "in"},
-		{"Iowa", "ia"},
-		{"Kansas", "ks"},
-		{"Kentucky", "ky"},
-		{"Louisiana", "la"},
-		{"Maine", "me"},
-		{"Maryland", "md"},
-		{"Massachusetts", "ma"},
-		{"Michigan", "mi"},
-		{"Minnesota", "mn"},
-		{"Mississippi", "ms"},
-		{"Missouri", "mo"},
-		{"Montana", "mt"},
-		{"Nebraska", "ne"},
-		{"Nevada", "nv"},
-		{"New_Hampshire", "nh"},
-		{"New_Jersey", "nj"},
-		{"New_Mexico", "nm"},
-		{"New_York", "ny"},
-		{"North_Carolina", "nc"},
-		{"North_Dakota", "nd"},
-		{"Ohio", "oh"},
-		{"Oklahoma", "ok"},
-		{"Oregon", "or"},
-		{"Pennsylvania", "pa"},
-		{"Rhode_Island", "ri"},
-		{"South_Carolina", "sc"},
-		{"South_Dakota", "sd"},
-		{"Tennessee", "tn"},
-		{"Texas", "tx"},
-		{"Utah", "ut"},
-		{"Vermont", "vt"},
-		{"Virginia", "va"},
-		{"Washington", "wa"},
-		{"West_Virginia", "wv"},
-		{"Wisconsin", "wi"},
-		{"Wyoming", "wy"},
+	// The states, including the District of Columbia
+	States = []State{
+		{"Alabama", "Alabama", "AL", "01", South, EastSouthCentral},
+		{"Alaska", "Alaska", "AK", "02", West, Pacific},
+		{"Arizona", "Arizona", "AZ", "04", West, Mountain},
+		{"Arkansas", "Arkansas", "AR", "05", South, WestSouthCentral},
+		{"California", "California", "CA", "06", West, Pacific},
+		{"Colorado", "Colorado", "CO", "08", West, Mountain},
+		{"Connecticut", "Connecticut", "CT", "09", Northeast, NewEngland},
+		{"Delaware", "Delaware", "DE", "10", South, SouthAtlantic},
+		{"District of Columbia", "District_of_Columbia", "DC", "11", South, SouthAtlantic},
+		{"Florida", "Florida", "FL", "12", South, SouthAtlantic},
+		{"Georgia", "Georgia", "GA", "13", South, SouthAtlantic},
+		{"Hawaii", "Hawaii", "HI", "15", West, Pacific},
+		{"Idaho", "Idaho", "ID", "16", West, Mountain},
+		{"Illinois", "Illinois", "IL", "17", Midwest, EastNorthCentral},
+		{"Indiana", "Indiana", "IN", "18", Midwest, EastNorthCentral},
+		{"Iowa", "Iowa", "IA", "19", Midwest, WestNorthCentral},
+		{"Kansas", "Kansas", "KS", "20", Midwest, WestNorthCentral},
+		{"Kentucky", "Kentucky", "KY", "21", South, EastSouthCentral},
+		{"Louisiana", "Louisiana", "LA", "22", South, WestSouthCentral},
+		{"Maine", "Maine", "ME", "23", Northeast, NewEngland},
+		{"Maryland", "Maryland", "MD", "24", South, SouthAtlantic},
+		{"Massachusetts", "Massachusetts", "MA", "25", Northeast, NewEngland},
+		{"Michigan", "Michigan", "MI", "26", Midwest, EastNorthCentral},
+		{"Minnesota", "Minnesota", "MN", "27", Midwest, WestNorthCentral},
+		{"Mississippi", "Mississippi", "MS", "28", South, EastSouthCentral},
+		{"Missouri", "Missouri", "MO", "29", Midwest, WestNorthCentral},
+		{"Montana", "Montana", "MT", "30", West, Mountain},
+		{"Nebraska", "Nebraska", "NE", "31", Midwest, WestNorthCentral},
+		{"Nevada", "Nevada", "NV", "32", West, Mountain},
+		{"New Hampshire", "New_Hampshire", "NH", "33", Northeast, NewEngland},
+		{"New Jersey", "New_Jersey", "NJ", "34", Northeast, MiddleAtlantic},
+		{"New Mexico", "New_Mexico", "NM", "35", West, Mountain},
+		{"New York", "New_York", "NY", "36", Northeast, MiddleAtlantic},
+		{"North Carolina", "North_Carolina", "NC", "37", South, SouthAtlantic},
+		{"North Dakota", "North_Dakota", "ND", "38", Midwest, WestNorthCentral},
+		{"Ohio", "Ohio", "OH", "39", Midwest, EastNorthCentral},
+		{"Oklahoma", "Oklahoma", "OK", "40", South, WestSouthCentral},
+		{"Oregon", "Oregon", "OR", "41", West, Pacific},
+		{"Pennsylvania", "Pennsylvania", "PA", "42", Northeast, MiddleAtlantic},
+		{"Rhode Island", "Rhode_Island", "RI", "44", Northeast, NewEngland},
+		{"South Carolina", "South_Carolina", "SC", "45", South, SouthAtlantic},
+		{"South Dakota", "South_Dakota", "SD", "46", Midwest, WestNorthCentral},
+		{"Tennessee", "Tennessee", "TN", "47", South, EastSouthCentral},
+		{"Texas", "Texas", "TX", "48", South, WestSouthCentral},
+		{"Utah", "Utah", "UT", "49", West, Mountain},
+		{"Vermont", "Vermont", "VT", "50", Northeast, NewEngland},
+		{"Virginia", "Virginia", "VA", "51", South, SouthAtlantic},
+		{"Washington", "Washington", "WA", "53", West, Pacific},
+		{"West Virginia", "West_Virginia", "WV", "54", South, SouthAtlantic},
+		{"Wisconsin", "Wisconsin", "WI", "55", Midwest, EastNorthCentral},
+		{"Wyoming", "Wyoming", "WY", "56", West, Mountain},
 	}
+
+	// Puerto Rico is not in States, use SelectStates to include it.  It
+	// is not part of any census region or division.
+	PuertoRico = State{"Puerto Rico", "Puerto_Rico", "PR", "72", NoRegion, NoDivision}
 )
+
+// SelectStates returns States, followed by Puerto Rico if pr is true.
+func SelectStates(pr bool) []State {
+
+	if !pr {
+		return States
+	}
+
+	st := make([]State, 0, len(States)+1)
+	st = append(st, States...)
+	return append(st, PuertoRico)
+}
+
+// allStates returns States and Puerto Rico, for the lookups.
+func allStates() []State {
+	return SelectStates(true)
+}
+
+// StateByPostal returns the state with the given postal code, in upper or
+// lower case.
+func StateByPostal(postal string) (State, bool) {
+	for _, s := range allStates() {
+		if strings.EqualFold(s.Postal, postal) {
+			return s, true
+		}
+	}
+	return State{}, false
+}
+
+// StateByFIPS returns the state with the given FIPS code.  The leading
+// zero may be omitted.
+func StateByFIPS(fips string) (State, bool) {
+	if len(fips) == 1 {
+		fips = "0" + fips
+	}
+	for _, s := range allStates() {
+		if s.FIPS == fips {
+			return s, true
+		}
+	}
+	return State{}, false
+}
+
+// StateByName returns the state with the given name, ignoring case.  The
+// words of the name may be separated by spaces or underscores, so the
+// census directory names are also accepted.
+func StateByName(name string) (State, bool) {
+	name = strings.ReplaceAll(name, "_", " ")
+	for _, s := range allStates() {
+		if strings.EqualFold(s.Name, name) {
+			return s, true
+		}
+	}
+	return State{}, false
+}
+
+// LookupState finds a state by its postal code, FIPS code or name, e.g.
+// "MI", "26" or "Michigan".
+func LookupState(key string) (State, error) {
+
+	key = strings.TrimSpace(key)
+	if s, ok := StateByPostal(key); ok {
+		return s, nil
+	}
+	if s, ok := StateByFIPS(key); ok {
+		return s, nil
+	}
+	if s, ok := StateByName(key); ok {
+		return s, nil
+	}
+
+	return State{}, fmt.Errorf("unknown state '%s'", key)
+}
+
+// The postal codes of the states sharing a land border with each state
+var neighbors = map[string]string{
+	"AL": "FL GA MS TN",
+	"AZ": "CA CO NV NM UT",
+	"AR": "LA MS MO OK TN TX",
+	"CA": "AZ NV OR",
+	"CO": "AZ KS NE NM OK UT WY",
+	"CT": "MA NY RI",
+	"DE": "MD NJ PA",
+	"DC": "MD VA",
+	"FL": "AL GA",
+	"GA": "AL FL NC SC TN",
+	"ID": "MT NV OR UT WA WY",
+	"IL": "IN IA KY MO WI",
+	"IN": "IL KY MI OH",
+	"IA": "IL MN MO NE SD WI",
+	"KS": "CO MO NE OK",
+	"KY": "IL IN MO OH TN VA WV",
+	"LA": "AR MS TX",
+	"ME": "NH",
+	"MD": "DE DC PA VA WV",
+	"MA": "CT NH NY RI VT",
+	"MI": "IN OH WI",
+	"MN": "IA ND SD WI",
+	"MS": "AL AR LA TN",
+	"MO": "AR IL IA KS KY NE OK TN",
+	"MT": "ID ND SD WY",
+	"NE": "CO IA KS MO SD WY",
+	"NV": "AZ CA ID OR UT",
+	"NH": "ME MA VT",
+	"NJ": "DE NY PA",
+	"NM": "AZ CO OK TX UT",
+	"NY": "CT MA NJ PA VT",
+	"NC": "GA SC TN VA",
+	"ND": "MN MT SD",
+	"OH": "IN KY MI PA WV",
+	"OK": "AR CO KS MO NM TX",
+	"OR": "CA ID NV WA",
+	"PA": "DE MD NJ NY OH WV",
+	"RI": "CT MA",
+	"SC": "GA NC",
+	"SD": "IA MN MT NE ND WY",
+	"TN": "AL AR GA KY MS MO NC VA",
+	"TX": "AR LA NM OK",
+	"UT": "AZ CO ID NV NM WY",
+	"VT": "MA NH NY",
+	"VA": "DC KY MD NC TN WV",
+	"WA": "ID OR",
+	"WV": "KY MD OH PA VA",
+	"WI": "IL IA MI MN",
+	"WY": "CO ID MT NE SD UT",
+}
+
+// The approximate longitude and latitude extent of each state.  The
+// Aleutian Islands west of the antimeridian are given longitudes below
+// -180.
+var extents = map[string]orb.Bound{
+	"AL": {Min: orb.Point{-88.47, 30.22}, Max: orb.Point{-84.89, 35.01}},
+	"AK": {Min: orb.Point{-188.00, 51.21}, Max: orb.Point{-129.98, 71.39}},
+	"AZ": {Min: orb.Point{-114.82, 31.33}, Max: orb.Point{-109.04, 37.00}},
+	"AR": {Min: orb.Point{-94.62, 33.00}, Max: orb.Point{-89.64, 36.50}},
+	"CA": {Min: orb.Point{-124.41, 32.53}, Max: orb.Point{-114.13, 42.01}},
+	"CO": {Min: orb.Point{-109.06, 36.99}, Max: orb.Point{-102.04, 41.00}},
+	"CT": {Min: orb.Point{-73.73, 40.98}, Max: orb.Point{-71.79, 42.05}},
+	"DE": {Min: orb.Point{-75.79, 38.45}, Max: orb.Point{-75.05, 39.84}},
+	"DC": {Min: orb.Point{-77.12, 38.79}, Max: orb.Point{-76.91, 39.00}},
+	"FL": {Min: orb.Point{-87.63, 24.52}, Max: orb.Point{-80.03, 31.00}},
+	"GA": {Min: orb.Point{-85.61, 30.36}, Max: orb.Point{-80.84, 35.00}},
+	"HI": {Min: orb.Point{-178.33, 18.91}, Max: orb.Point{-154.81, 28.40}},
+	"ID": {Min: orb.Point{-117.24, 41.99}, Max: orb.Point{-111.04, 49.00}},
+	"IL": {Min: orb.Point{-91.51, 36.97}, Max: orb.Point{-87.50, 42.51}},
+	"IN": {Min: orb.Point{-88.10, 37.77}, Max: orb.Point{-84.78, 41.76}},
+	"IA": {Min: orb.Point{-96.64, 40.38}, Max: orb.Point{-90.14, 43.50}},
+	"KS": {Min: orb.Point{-102.05, 36.99}, Max: orb.Point{-94.59, 40.00}},
+	"KY": {Min: orb.Point{-89.57, 36.50}, Max: orb.Point{-81.96, 39.15}},
+	"LA": {Min: orb.Point{-94.04, 28.93}, Max: orb.Point{-88.82, 33.02}},
+	"ME": {Min: orb.Point{-71.08, 42.98}, Max: orb.Point{-66.95, 47.46}},
+	"MD": {Min: orb.Point{-79.49, 37.91}, Max: orb.Point{-75.05, 39.72}},
+	"MA": {Min: orb.Point{-73.51, 41.24}, Max: orb.Point{-69.93, 42.89}},
+	"MI": {Min: orb.Point{-90.42, 41.70}, Max: orb.Point{-82.41, 48.31}},
+	"MN": {Min: orb.Point{-97.24, 43.50}, Max: orb.Point{-89.49, 49.38}},
+	"MS": {Min: orb.Point{-91.66, 30.17}, Max: orb.Point{-88.10, 35.00}},
+	"MO": {Min: orb.Point{-95.77, 35.99}, Max: orb.Point{-89.10, 40.61}},
+	"MT": {Min: orb.Point{-116.05, 44.36}, Max: orb.Point{-104.04, 49.00}},
+	"NE": {Min: orb.Point{-104.05, 40.00}, Max: orb.Point{-95.31, 43.00}},
+	"NV": {Min: orb.Point{-120.01, 35.00}, Max: orb.Point{-114.04, 42.00}},
+	"NH": {Min: orb.Point{-72.56, 42.70}, Max: orb.Point{-70.61, 45.31}},
+	"NJ": {Min: orb.Point{-75.56, 38.93}, Max: orb.Point{-73.89, 41.36}},
+	"NM": {Min: orb.Point{-109.05, 31.33}, Max: orb.Point{-103.00, 37.00}},
+	"NY": {Min: orb.Point{-79.76, 40.50}, Max: orb.Point{-71.86, 45.02}},
+	"NC": {Min: orb.Point{-84.32, 33.84}, Max: orb.Point{-75.46, 36.59}},
+	"ND": {Min: orb.Point{-104.05, 45.94}, Max: orb.Point{-96.55, 49.00}},
+	"OH": {Min: orb.Point{-84.82, 38.40}, Max: orb.Point{-80.52, 41.98}},
+	"OK": {Min: orb.Point{-103.00, 33.62}, Max: orb.Point{-94.43, 37.00}},
+	"OR": {Min: orb.Point{-124.57, 41.99}, Max: orb.Point{-116.46, 46.29}},
+	"PA": {Min: orb.Point{-80.52, 39.72}, Max: orb.Point{-74.69, 42.27}},
+	"RI": {Min: orb.Point{-71.91, 41.15}, Max: orb.Point{-71.12, 42.02}},
+	"SC": {Min: orb.Point{-83.35, 32.03}, Max: orb.Point{-78.54, 35.22}},
+	"SD": {Min: orb.Point{-104.06, 42.48}, Max: orb.Point{-96.44, 45.95}},
+	"TN": {Min: orb.Point{-90.31, 34.98}, Max: orb.Point{-81.65, 36.68}},
+	"TX": {Min: orb.Point{-106.65, 25.84}, Max: orb.Point{-93.51, 36.50}},
+	"UT": {Min: orb.Point{-114.05, 37.00}, Max: orb.Point{-109.04, 42.00}},
+	"VT": {Min: orb.Point{-73.44, 42.73}, Max: orb.Point{-71.46, 45.02}},
+	"VA": {Min: orb.Point{-83.68, 36.54}, Max: orb.Point{-75.24, 39.47}},
+	"WA": {Min: orb.Point{-124.85, 45.54}, Max: orb.Point{-116.92, 49.00}},
+	"WV": {Min: orb.Point{-82.64, 37.20}, Max: orb.Point{-77.72, 40.64}},
+	"WI": {Min: orb.Point{-92.89, 42.49}, Max: orb.Point{-86.25, 47.31}},
+	"WY": {Min: orb.Point{-111.06, 40.99}, Max: orb.Point{-104.05, 45.01}},
+	"PR": {Min: orb.Point{-67.95, 17.88}, Max: orb.Point{-65.22, 18.52}},
+}
+
+// Contains returns true if pt is within the state's extent, allowing a
+// margin of half a degree.
+func (s State) Contains(pt orb.Point) bool {
+	b, ok := extents[s.Postal]
+	if !ok {
+		return true
+	}
+	if s.Postal == "AK" && pt[0] > 0 {
+		pt[0] -= 360
+	}
+	return b.Pad(0.5).Contains(pt)
+}
+
+// Neighbors returns the states that share a land border with s.
+func (s State) Neighbors() []State {
+	var nb []State
+	for _, p := range strings.Fields(neighbors[s.Postal]) {
+		t, _ := StateByPostal(p)
+		nb = append(nb, t)
+	}
+	return nb
+}
+
+// ParseStates returns the states in a comma separated list of postal
+// codes, FIPS codes or names.  An empty list gives all the states, with
+// Puerto Rico included if pr is true.
+func ParseStates(list string, pr bool) ([]State, error) {
+
+	if strings.TrimSpace(list) == "" {
+		return SelectStates(pr), nil
+	}
+
+	var states []State
+	seen := make(map[string]bool)
+	for _, key := range strings.Split(list, ",") {
+		s, err := LookupState(key)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[s.FIPS] {
+			states = append(states, s)
+			seen[s.FIPS] = true
+		}
+	}
+
+	return states, nil
+}
+
+// FIPSSet returns the FIPS codes of the given states, optionally along
+// with the FIPS codes of their neighbors.  The result can be matched
+// against Region.StateId.
+func FIPSSet(states []State, withNeighbors bool) map[string]bool {
+
+	set := make(map[string]bool)
+	for _, s := range states {
+		set[s.FIPS] = true
+		if withNeighbors {
+			for _, t := range s.Neighbors() {
+				set[t.FIPS] = true
+			}
+		}
+	}
+
+	return set
+}
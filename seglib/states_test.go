@@ -0,0 +1,84 @@
+package seglib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseStates(t *testing.T) {
+
+	for _, tc := range []struct {
+		list string
+		pr   bool
+		want []string
+	}{
+		{"MI", false, []string{"MI"}},
+		{"mi, 39 ,Indiana", false, []string{"MI", "OH", "IN"}},
+		{"New_Hampshire,new hampshire,33", false, []string{"NH"}},
+		{"6,DC", false, []string{"CA", "DC"}},
+		{"PR", false, []string{"PR"}},
+	} {
+		states, err := ParseStates(tc.list, tc.pr)
+		if err != nil {
+			t.Errorf("%q: %v", tc.list, err)
+			continue
+		}
+		var got []string
+		for _, s := range states {
+			got = append(got, s.Postal)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %v, expected %v", tc.list, got, tc.want)
+		}
+	}
+
+	for _, list := range []string{"XX", "MI,,OH", "99", "Michigan State"} {
+		if _, err := ParseStates(list, false); err == nil {
+			t.Errorf("%q: no error", list)
+		}
+	}
+
+	// An empty list is every state, with Puerto Rico if requested
+	all, _ := ParseStates(" ", false)
+	allpr, _ := ParseStates("", true)
+	if len(all) != 51 || len(allpr) != 52 || allpr[51].Postal != "PR" {
+		t.Errorf("got %d and %d states, expected 51 and 52", len(all), len(allpr))
+	}
+}
+
+func TestFIPSSet(t *testing.T) {
+
+	states, err := ParseStates("MI", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := FIPSSet(states, false); !reflect.DeepEqual(got, map[string]bool{"26": true}) {
+		t.Errorf("got %v, expected only Michigan", got)
+	}
+
+	want := map[string]bool{"26": true, "18": true, "39": true, "55": true}
+	if got := FIPSSet(states, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, expected %v", got, want)
+	}
+}
+
+func TestNeighbors(t *testing.T) {
+
+	// Neighboring is symmetric
+	for _, s := range allStates() {
+		for _, n := range s.Neighbors() {
+			if n.Postal == "" {
+				t.Errorf("%s has an unknown neighbor", s.Postal)
+				continue
+			}
+			found := false
+			for _, m := range n.Neighbors() {
+				found = found || m.Postal == s.Postal
+			}
+			if !found {
+				t.Errorf("%s is a neighbor of %s, but not the reverse", n.Postal, s.Postal)
+			}
+		}
+	}
+}
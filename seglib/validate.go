@@ -0,0 +1,242 @@
+package seglib
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Problem describes an inconsistency found in a region.
+type Problem struct {
+
+	// The identifier of the region, its GeoId
+	ID string
+
+	// The field, or group of fields, with the problem
+	Field string
+
+	Msg string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s: %s", p.ID, p.Field, p.Msg)
+}
+
+// Validator checks the regions of one file.  The regions of a file should
+// all have the same type and distinct identifiers, so a Validator
+// remembers what it has seen.
+type Validator struct {
+
+	// The expected type of the regions.  If nil, the type of the first
+	// region is expected.
+	Type *RegionType
+
+	seen map[string]bool
+}
+
+// Valid returns true if t is a known region type.
+func (t RegionType) Valid() bool {
+	_, ok := regionTypes[t]
+	return ok
+}
+
+// Check returns the problems found in r.
+func (v *Validator) Check(r *Region) []Problem {
+
+	var probs []Problem
+	add := func(field, format string, args ...interface{}) {
+		probs = append(probs, Problem{ID: r.GeoId, Field: field, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	if v.seen == nil {
+		v.seen = make(map[string]bool)
+	}
+
+	// The type must be known, and the same in every region
+	if !r.Type.Valid() {
+		add("Type", "unknown region type %d", r.Type)
+		return probs
+	}
+	if v.Type == nil {
+		t := r.Type
+		v.Type = &t
+	} else if r.Type != *v.Type {
+		add("Type", "region type is %s, expected %s", r.Type, *v.Type)
+	}
+
+	v.checkIDs(r, add)
+	checkCounts(r, add)
+	checkMetrics(r, add)
+
+	if st, ok := StateByFIPS(r.StateId); ok && !st.Contains(r.Location) {
+		add("Location", "(%.4f, %.4f) is outside of %s", r.Location[0], r.Location[1], st.Name)
+	}
+
+	return probs
+}
+
+func (v *Validator) checkIDs(r *Region, add func(string, string, ...interface{})) {
+
+	// Every identifier starts with the state code, including those of
+	// ZCTAs, which are split at state lines, so the identifiers are
+	// unique for all region types.
+	id := r.GeoId
+	if n := r.Type.GEOIDLen(); len(id) != n {
+		add("ID", "identifier '%s' has length %d, expected %d", id, len(id), n)
+	} else if v.seen[id] {
+		add("ID", "identifier is repeated")
+	}
+	v.seen[id] = true
+
+	if _, ok := StateByFIPS(r.StateId); !ok || len(r.StateId) != 2 {
+		add("StateId", "unknown state '%s'", r.StateId)
+	} else if !strings.HasPrefix(id, r.StateId) {
+		add("ID", "identifier does not start with the state code %s", r.StateId)
+	}
+
+	// Identifiers made of FIPS codes are numeric, legislative districts
+	// may contain letters.
+	switch r.Type {
+	case Tract, BlockGroup, CountySubdivision, Block, County, Place, ZCTA:
+		for _, c := range id {
+			if c < '0' || c > '9' {
+				add("ID", "identifier '%s' is not numeric", id)
+				break
+			}
+		}
+	}
+
+	// The field for the region type should be set, and the fields for the
+	// other types should not be.
+	typed := []struct {
+		t RegionType
+		x string
+	}{
+		{CountySubdivision, r.Cousub},
+		{Tract, r.Tract},
+		{BlockGroup, r.BlockGroup},
+		{Block, r.Block},
+	}
+	for _, y := range typed {
+		if y.t != r.Type && y.x != "" {
+			add("Type", "region type is %s but the %s identifier is set", r.Type, y.t)
+		}
+		if y.t == r.Type && y.x == "" {
+			add("Type", "the %s identifier is not set", r.Type)
+		}
+	}
+}
+
+func checkCounts(r *Region, add func(string, string, ...interface{})) {
+
+	nonneg := []struct {
+		name string
+		x    int
+	}{
+		{"TotalPop", r.TotalPop},
+		{"BlackOnlyPop", r.BlackOnlyPop},
+		{"WhiteOnlyPop", r.WhiteOnlyPop},
+		{"HispanicPop", r.HispanicPop},
+		{"VAPTotalPop", r.VAPTotalPop},
+		{"HousingUnits", r.HousingUnits},
+		{"OccupiedUnits", r.OccupiedUnits},
+		{"VacantUnits", r.VacantUnits},
+	}
+	for _, y := range nonneg {
+		if y.x < 0 {
+			add(y.name, "negative count %d", y.x)
+		}
+	}
+	for j, x := range r.RacePop {
+		if x < 0 {
+			add(RaceNames[j]+"Pop", "negative count %d", x)
+		}
+	}
+
+	if r.WhiteOnlyPop+r.BlackOnlyPop > r.TotalPop {
+		add("TotalPop", "White (%d) and Black (%d) exceed the total (%d)", r.WhiteOnlyPop, r.BlackOnlyPop, r.TotalPop)
+	}
+	if r.HispanicPop+r.NHWhitePop+r.NHBlackPop > r.TotalPop {
+		add("HispanicPop", "Hispanic (%d) and non-Hispanic White (%d) and Black (%d) exceed the total (%d)",
+			r.HispanicPop, r.NHWhitePop, r.NHBlackPop, r.TotalPop)
+	}
+	if r.RacePop.Total() > r.TotalPop {
+		add("RacePop", "the races (%d) exceed the total (%d)", r.RacePop.Total(), r.TotalPop)
+	}
+	if r.VAPWhiteOnlyPop+r.VAPBlackOnlyPop > r.VAPTotalPop {
+		add("VAPTotalPop", "White (%d) and Black (%d) exceed the total (%d)", r.VAPWhiteOnlyPop, r.VAPBlackOnlyPop, r.VAPTotalPop)
+	}
+	if r.OccupiedUnits+r.VacantUnits != r.HousingUnits {
+		add("HousingUnits", "occupied (%d) and vacant (%d) units do not add up to %d", r.OccupiedUnits, r.VacantUnits, r.HousingUnits)
+	}
+	for k, x := range r.Groups {
+		if x < 0 {
+			add("Groups", "group %s has negative count %d", k, x)
+		}
+	}
+
+	// The CBSA totals include the region
+	if r.CBSATotalPop != 0 && r.CBSATotalPop < r.TotalPop {
+		add("CBSATotalPop", "CBSA total (%d) is less than the region total (%d)", r.CBSATotalPop, r.TotalPop)
+	}
+}
+
+func checkMetrics(r *Region, add func(string, string, ...interface{})) {
+
+	// These are proportions, the housing measures are missing when there
+	// is no housing data and the Hispanic measures are missing unless
+	// they were requested.
+	props := []struct {
+		name    string
+		x       float64
+		missing bool
+	}{
+		{"PBlack", r.PBlack, false},
+		{"PWhite", r.PWhite, false},
+		{"BlackIsolation", r.BlackIsolation, false},
+		{"WhiteIsolation", r.WhiteIsolation, false},
+		{"BODissimilarity", r.BODissimilarity, false},
+		{"WODissimilarity", r.WODissimilarity, false},
+		{"BlackIsolationHU", r.BlackIsolationHU, true},
+		{"WhiteIsolationHU", r.WhiteIsolationHU, true},
+		{"VacancyRate", r.VacancyRate, true},
+		{"HispanicIsolation", r.HispanicIsolation, true},
+		{"HODissimilarity", r.HODissimilarity, true},
+	}
+	for _, p := range props {
+		if math.IsNaN(p.x) {
+			if !p.missing {
+				add(p.name, "value is NaN")
+			}
+		} else if p.x < 0 || p.x > 1 {
+			add(p.name, "value %f is outside [0, 1]", p.x)
+		}
+	}
+	for k, x := range r.GroupIsolation {
+		if !(x >= 0 && x <= 1) {
+			add("GroupIsolation", "group %s has value %f outside [0, 1]", k, x)
+		}
+	}
+	for k, x := range r.GroupDissimilarity {
+		if !(x >= 0 && x <= 1) {
+			add("GroupDissimilarity", "group %s has value %f outside [0, 1]", k, x)
+		}
+	}
+
+	// There are at most four groups in the entropy measures
+	for _, p := range []struct {
+		name string
+		x    float64
+	}{
+		{"LocalEntropy", r.LocalEntropy},
+		{"RegionalEntropy", r.RegionalEntropy},
+	} {
+		if !(p.x >= 0 && p.x <= math.Log(4)+1e-9) {
+			add(p.name, "value %f is outside [0, log(4)]", p.x)
+		}
+	}
+
+	if r.RegionPop < 0 || r.Neighbors < 0 || r.RegionRadius < 0 {
+		add("RegionPop", "negative neighborhood size")
+	}
+}
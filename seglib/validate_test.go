@@ -0,0 +1,108 @@
+package seglib
+
+import (
+	"math"
+	"testing"
+
+	"github.com/paulmach/orb"
+)
+
+// validRegion returns a tract in Ann Arbor that passes all of the checks.
+func validRegion() *Region {
+	return &Region{
+		StateId:           "26",
+		County:            "161",
+		Tract:             "26161400100",
+		GeoId:             "26161400100",
+		Type:              Tract,
+		Location:          orb.Point{-83.74, 42.28},
+		TotalPop:          100,
+		BlackOnlyPop:      20,
+		WhiteOnlyPop:      50,
+		HispanicPop:       10,
+		NHWhitePop:        50,
+		NHBlackPop:        20,
+		HousingUnits:      40,
+		OccupiedUnits:     35,
+		VacantUnits:       5,
+		VacancyRate:       math.NaN(),
+		BlackIsolationHU:  math.NaN(),
+		WhiteIsolationHU:  math.NaN(),
+		HispanicIsolation: math.NaN(),
+		HODissimilarity:   math.NaN(),
+	}
+}
+
+func TestValidator(t *testing.T) {
+
+	for _, tc := range []struct {
+		name  string
+		edit  func(r *Region)
+		field string
+	}{
+		{"valid", func(r *Region) {}, ""},
+		{"short id", func(r *Region) { r.GeoId = "2616140010" }, "ID"},
+		{"other state", func(r *Region) { r.GeoId = "39161400100" }, "ID"},
+		{"bad state", func(r *Region) { r.StateId = "99" }, "StateId"},
+		{"not numeric", func(r *Region) { r.GeoId = "2616140010x" }, "ID"},
+		{"wrong type field", func(r *Region) { r.Cousub = "2616100000" }, "Type"},
+		{"negative", func(r *Region) { r.VAPTotalPop = -1 }, "VAPTotalPop"},
+		{"too many", func(r *Region) { r.WhiteOnlyPop = 90 }, "TotalPop"},
+		{"too many Hispanic", func(r *Region) { r.HispanicPop = 40 }, "HispanicPop"},
+		{"housing", func(r *Region) { r.VacantUnits = 6 }, "HousingUnits"},
+		{"isolation", func(r *Region) { r.BlackIsolation = 1.5 }, "BlackIsolation"},
+		{"missing", func(r *Region) { r.WODissimilarity = math.NaN() }, "WODissimilarity"},
+		{"group", func(r *Region) { r.GroupIsolation = map[string]float64{"Asian": -0.1} }, "GroupIsolation"},
+		{"location", func(r *Region) { r.Location = orb.Point{-100, 42.28} }, "Location"},
+	} {
+		r := validRegion()
+		tc.edit(r)
+		var v Validator
+		probs := v.Check(r)
+		if tc.field == "" {
+			if len(probs) > 0 {
+				t.Errorf("%s: unexpected problems %v", tc.name, probs)
+			}
+			continue
+		}
+		if len(probs) == 0 {
+			t.Errorf("%s: no problems found", tc.name)
+		}
+		for _, p := range probs {
+			if p.Field != tc.field {
+				t.Errorf("%s: got %v, expected a problem with %s", tc.name, p, tc.field)
+			}
+		}
+	}
+}
+
+func TestValidatorRepeated(t *testing.T) {
+
+	// The parts of a ZCTA in two states are distinct regions
+	mi := &Region{StateId: "26", GeoId: "2649265", Type: ZCTA, Location: orb.Point{-84.3, 41.8}}
+	oh := &Region{StateId: "39", GeoId: "3949265", Type: ZCTA, Location: orb.Point{-84.3, 41.7}}
+
+	var v Validator
+	for _, r := range []*Region{mi, oh} {
+		r.VacancyRate = math.NaN()
+		r.BlackIsolationHU = math.NaN()
+		r.WhiteIsolationHU = math.NaN()
+		r.HispanicIsolation = math.NaN()
+		r.HODissimilarity = math.NaN()
+		if probs := v.Check(r); len(probs) > 0 {
+			t.Errorf("unexpected problems %v", probs)
+		}
+	}
+
+	probs := v.Check(mi)
+	if len(probs) != 1 || probs[0].Msg != "identifier is repeated" {
+		t.Errorf("got %v, expected a repeated identifier", probs)
+	}
+
+	// A region of another type is a problem
+	r := validRegion()
+	probs = v.Check(r)
+	if len(probs) != 1 || probs[0].Field != "Type" {
+		t.Errorf("got %v, expected a problem with the type", probs)
+	}
+}
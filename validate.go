@@ -0,0 +1,104 @@
+// Check the regions in raw, metrics or normalized gob files for malformed
+// identifiers, impossible counts, and out of range metrics.
+//
+// Usage: go run validate.go [-sumlevel=tract] [-max=20] file...
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/kshedden/segregation/seglib"
+)
+
+var cfg seglib.Config
+
+// validate checks the regions in one file, printing up to maxprint of
+// the problems, and returns the number of problems found.
+func validate(fname string, sumlevel string, maxprint int) int {
+
+	var v seglib.Validator
+
+	rr, err := seglib.OpenRegions(fname)
+	if err != nil {
+		panic(err)
+	}
+	defer rr.Close()
+	hdr := rr.Header()
+	fmt.Printf("%s: %s\n", fname, hdr)
+
+	// The expected region type is given by the flag, or else by the file
+	// header
+	var n, nprob int
+	t := hdr.Type
+	if sumlevel != "" {
+		t, err = seglib.ParseRegionType(sumlevel)
+		if err != nil {
+			panic(err)
+		}
+		if t != hdr.Type {
+			fmt.Printf("  the header has region type %s, expected %s\n", hdr.Type, t)
+			nprob++
+		}
+	}
+	v.Type = &t
+
+	byField := make(map[string]int)
+	for rr.Next() {
+		n++
+		for _, p := range v.Check(rr.Region()) {
+			if nprob < maxprint {
+				fmt.Printf("  %s\n", p)
+			}
+			nprob++
+			byField[p.Field]++
+		}
+	}
+	if err := rr.Err(); err != nil {
+		panic(err)
+	}
+
+	if nprob > maxprint {
+		fmt.Printf("  ... %d more\n", nprob-maxprint)
+	}
+
+	var fields []string
+	for k := range byField {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+	for _, k := range fields {
+		fmt.Printf("  %-20s %d\n", k, byField[k])
+	}
+	fmt.Printf("%d regions, %d problems in '%s'\n", n, nprob, fname)
+
+	return nprob
+}
+
+func main() {
+
+	sumlevel := flag.String("sumlevel", "", "Expected summary level (default from the file header)")
+	maxprint := flag.Int("max", 20, "Maximum number of problems to print per file")
+	cfg.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	if err := cfg.Resolve(); err != nil {
+		panic(err)
+	}
+
+	if flag.NArg() == 0 {
+		os.Stderr.WriteString("No files to validate\n")
+		os.Exit(1)
+	}
+
+	var nprob int
+	for _, fname := range flag.Args() {
+		nprob += validate(cfg.OutPath(fname), *sumlevel, *maxprint)
+	}
+
+	if nprob > 0 {
+		os.Exit(1)
+	}
+}